@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/aerospike/aerospike-client-go"
+)
+
+const (
+	LIST_APPEND = iota
+	LIST_INSERT
+	LIST_POP
+	LIST_GET
+	LIST_GET_RANGE
+)
+
+const (
+	MAP_PUT = iota
+	MAP_PUT_ITEMS
+	MAP_GET_BY_KEY
+	MAP_REMOVE_BY_KEY
+)
+
+// cdtIndex picks an index likely to exist in a list, given its constraints.
+func cdtIndex(c *ListConstraints) int {
+	if c.Min > 0 {
+		return int(rand.Int63n(c.Min))
+	}
+	return 0
+}
+
+func newListOp(kind int, b *BinConstraints) *aerospike.Operation {
+	c := b.Value.List
+	switch kind {
+	case LIST_APPEND:
+		return aerospike.ListAppendOp(b.Name, GenerateValue(&c.Value))
+	case LIST_INSERT:
+		return aerospike.ListInsertOp(b.Name, cdtIndex(c), GenerateValue(&c.Value))
+	case LIST_POP:
+		return aerospike.ListPopOp(b.Name, cdtIndex(c))
+	case LIST_GET:
+		return aerospike.ListGetOp(b.Name, cdtIndex(c))
+	case LIST_GET_RANGE:
+		i := cdtIndex(c)
+		n := c.Max - int64(i)
+		if n < 1 {
+			n = 1
+		}
+		return aerospike.ListGetRangeOp(b.Name, i, int(randomInRange(1, n)))
+	}
+	return nil
+}
+
+// mapKey generates a map key from the key constraints, falling back to a
+// string sized by the map constraints, as GenerateMap does.
+func mapKey(c *MapConstraints) interface{} {
+	if k := GenerateValue(&c.Key); k != nil {
+		return k
+	}
+	return generateString(c.Min, c.Max)
+}
+
+func newMapOp(kind int, b *BinConstraints) *aerospike.Operation {
+	c := b.Value.Map
+	switch kind {
+	case MAP_PUT:
+		return aerospike.MapPutOp(aerospike.DefaultMapPolicy(), b.Name, mapKey(c), GenerateValue(&c.Value))
+	case MAP_PUT_ITEMS:
+		n := randomInRange(c.Min, c.Max)
+		if n < 1 {
+			n = 1
+		}
+		items := make(map[interface{}]interface{}, n)
+		for i := int64(0); i < n; i++ {
+			items[mapKey(c)] = GenerateValue(&c.Value)
+		}
+		return aerospike.MapPutItemsOp(aerospike.DefaultMapPolicy(), b.Name, items)
+	case MAP_GET_BY_KEY:
+		return aerospike.MapGetByKeyOp(b.Name, mapKey(c), aerospike.MapReturnType.VALUE)
+	case MAP_REMOVE_BY_KEY:
+		return aerospike.MapRemoveByKeyOp(b.Name, mapKey(c), aerospike.MapReturnType.NONE)
+	}
+	return nil
+}
+
+// cdtGenerator runs one operation, chosen from the mix, against a random bin
+// out of `bins`, recording the result in `stat`.
+func cdtGenerator(client *aerospike.Client, keys KeyGenerator, bins []*BinConstraints, mix *Mix, build func(int, *BinConstraints) *aerospike.Operation, stat *Stat, policy *aerospike.WritePolicy, ttls func() int64) func() bool {
+
+	return func() bool {
+		if len(bins) == 0 {
+			return false
+		}
+
+		op := build(mix.Pick(), bins[rand.Intn(len(bins))])
+		if op == nil {
+			return false
+		}
+
+		if k := keys.GetKey(); k != nil {
+			start := time.Now()
+			_, err := client.Operate(ttlPolicy(policy, ttls), k, op)
+			statUpdate(stat, err)
+			statForeground(stat, time.Since(start))
+			return true
+		}
+		return false
+	}
+}
+
+// listBins returns the list bins of the data model.
+func listBins(data *DataModel) []*BinConstraints {
+	bins := []*BinConstraints{}
+	for _, b := range data.Bins {
+		if b.Value.List != nil {
+			bins = append(bins, b)
+		}
+	}
+	return bins
+}
+
+// mapBins returns the map bins of the data model.
+func mapBins(data *DataModel) []*BinConstraints {
+	bins := []*BinConstraints{}
+	for _, b := range data.Bins {
+		if b.Value.Map != nil {
+			bins = append(bins, b)
+		}
+	}
+	return bins
+}
+
+func listMix(mix *ListMix) *Mix {
+	return NewMix(mix.Append, mix.Insert, mix.Pop, mix.Get, mix.GetRange)
+}
+
+func mapMix(mix *MapMix) *Mix {
+	return NewMix(mix.Put, mix.PutItems, mix.GetByKey, mix.RemoveByKey)
+}
+
+func ListGenerator(client *aerospike.Client, keys KeyGenerator, data *DataModel, mix *ListMix, policy *aerospike.WritePolicy, ttls func() int64) func() bool {
+	return cdtGenerator(client, keys, listBins(data), listMix(mix), newListOp, &CURRENT_STATS.Lists, policy, ttls)
+}
+
+func MapGenerator(client *aerospike.Client, keys KeyGenerator, data *DataModel, mix *MapMix, policy *aerospike.WritePolicy, ttls func() int64) func() bool {
+	return cdtGenerator(client, keys, mapBins(data), mapMix(mix), newMapOp, &CURRENT_STATS.Maps, policy, ttls)
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	yaml "gopkg.in/yaml.v2"
 	"io/ioutil"
+	"time"
 )
 
 // ----------------------------------------------------------------------------
@@ -61,10 +63,14 @@ type BinConstraints struct {
 	Indexed  bool        `json:"indexed,omitempty"`
 }
 
+// KeyConstraints describe the keys, which a template formats from their
+// index, as strings, or bytes when the key constraints are bytes.
 type KeyConstraints struct {
 	Namespace string      `json:"namespace"`
 	Set       string      `json:"set"`
 	Key       Constraints `json:"key"`
+	Template  string      `json:"template,omitempty"`
+	template  *KeyTemplate
 }
 
 type DataModel struct {
@@ -72,14 +78,223 @@ type DataModel struct {
 	Bins []*BinConstraints `json:"bins"`
 }
 
+type ScanOptions struct {
+	Concurrency      int      `json:"concurrency,omitempty"`
+	Priority         string   `json:"priority,omitempty"`
+	Bins             []string `json:"bins,omitempty"`
+	RecordsPerSecond int64    `json:"records_per_second,omitempty" yaml:"records_per_second,omitempty"`
+}
+
+type OperateMix struct {
+	Add     int64 `json:"add,omitempty"`
+	Append  int64 `json:"append,omitempty"`
+	Prepend int64 `json:"prepend,omitempty"`
+	Touch   int64 `json:"touch,omitempty"`
+	Get     int64 `json:"get,omitempty"`
+}
+
+type ReadMix struct {
+	Full   int64 `json:"full,omitempty"`
+	Exists int64 `json:"exists,omitempty"`
+	Header int64 `json:"header,omitempty"`
+	Bins   int64 `json:"bins,omitempty"`
+}
+
+type ListMix struct {
+	Append   int64 `json:"append,omitempty"`
+	Insert   int64 `json:"insert,omitempty"`
+	Pop      int64 `json:"pop,omitempty"`
+	Get      int64 `json:"get,omitempty"`
+	GetRange int64 `json:"get_range,omitempty" yaml:"get_range,omitempty"`
+}
+
+type MapMix struct {
+	Put         int64 `json:"put,omitempty"`
+	PutItems    int64 `json:"put_items,omitempty" yaml:"put_items,omitempty"`
+	GetByKey    int64 `json:"get_by_key,omitempty" yaml:"get_by_key,omitempty"`
+	RemoveByKey int64 `json:"remove_by_key,omitempty" yaml:"remove_by_key,omitempty"`
+}
+
+type UDFOptions struct {
+	Module   string        `json:"module,omitempty"`
+	Function string        `json:"function,omitempty"`
+	Args     []Constraints `json:"args,omitempty"`
+}
+
+type ProfileOptions struct {
+	RampUp       time.Duration `json:"ramp_up,omitempty" yaml:"ramp_up,omitempty"`
+	RampDown     time.Duration `json:"ramp_down,omitempty" yaml:"ramp_down,omitempty"`
+	Step         int64         `json:"step,omitempty"`
+	StepInterval time.Duration `json:"step_interval,omitempty" yaml:"step_interval,omitempty"`
+}
+
+type TTLBucket struct {
+	TTL    int64 `json:"ttl"`
+	Weight int64 `json:"weight"`
+}
+
+type TTLDistribution struct {
+	Min     int64       `json:"min,omitempty"`
+	Max     int64       `json:"max,omitempty"`
+	Buckets []TTLBucket `json:"buckets,omitempty"`
+}
+
+// Check returns an error for a range missing its max, or ending before its
+// min, and for buckets without any weight.
+func (d *TTLDistribution) Check() error {
+	if len(d.Buckets) > 0 {
+		for _, b := range d.Buckets {
+			if b.Weight > 0 {
+				return nil
+			}
+		}
+		return fmt.Errorf("every ttl bucket has a zero weight")
+	}
+	if d.Min > 0 && d.Max <= 0 {
+		return fmt.Errorf("ttl range has a min of %d but no max", d.Min)
+	}
+	if d.Max > 0 && d.Max <= d.Min {
+		return fmt.Errorf("ttl range max %d is not above its min %d", d.Max, d.Min)
+	}
+	return nil
+}
+
+type TTLVerifyOptions struct {
+	Sample float64       `json:"sample,omitempty"`
+	Grace  time.Duration `json:"grace,omitempty"`
+}
+
+// KeyDistribution chooses how keys are accessed: uniform, zipfian, hotspot,
+// gaussian, sequential, exponential, latest or drifting, or else replayed
+// from a trace file. Mean, deviation and step are fractions of the key space,
+// as are offset and span, which narrow the distribution to a window of the
+// keys.
+type KeyDistribution struct {
+	Type      string        `json:"type,omitempty"`
+	Theta     float64       `json:"theta,omitempty"`
+	HotOps    float64       `json:"hot_ops,omitempty" yaml:"hot_ops,omitempty"`
+	HotKeys   float64       `json:"hot_keys,omitempty" yaml:"hot_keys,omitempty"`
+	Mean      float64       `json:"mean,omitempty"`
+	Deviation float64       `json:"deviation,omitempty"`
+	Offset    float64       `json:"offset,omitempty"`
+	Span      float64       `json:"span,omitempty"`
+	Interval  time.Duration `json:"interval,omitempty"`
+	Step      float64       `json:"step,omitempty"`
+	Jump      bool          `json:"jump,omitempty"`
+	File      string        `json:"file,omitempty"`
+	Format    string        `json:"format,omitempty"`
+	Loop      bool          `json:"loop,omitempty"`
+}
+
+type GrowthOptions struct {
+	Bin    string `json:"bin,omitempty"`
+	Cap    int64  `json:"cap,omitempty"`
+	Policy string `json:"policy,omitempty"`
+}
+
 type LoadModel struct {
-	TTL     int64 `json:"ttl"`
-	Keys    int64 `json:"keys"`
-	Reads   int64 `json:"reads"`
-	Writes  int64 `json:"writes"`
-	Deletes int64 `json:"deletes"`
-	Queries int64 `json:"queries"`
-	Scans   int64 `json:"scans"`
+	TTL           int64                      `json:"ttl"`
+	TTLs          TTLDistribution            `json:"ttls,omitempty"`
+	TTLVerify     TTLVerifyOptions           `json:"ttl_verify,omitempty" yaml:"ttl_verify,omitempty"`
+	Keys          int64                      `json:"keys"`
+	Distribution  KeyDistribution            `json:"distribution,omitempty"`
+	Distributions map[string]KeyDistribution `json:"distributions,omitempty"`
+	Reads         int64                      `json:"reads"`
+	ReadMix       ReadMix                    `json:"read_mix,omitempty" yaml:"read_mix,omitempty"`
+	ReadBins      []string                   `json:"read_bins,omitempty" yaml:"read_bins,omitempty"`
+	Writes        int64                      `json:"writes"`
+	CASWrites     int64                      `json:"cas_writes,omitempty" yaml:"cas_writes,omitempty"`
+	CASRetries    *int64                     `json:"cas_retries,omitempty" yaml:"cas_retries,omitempty"`
+	HotKeys       int64                      `json:"hot_keys,omitempty" yaml:"hot_keys,omitempty"`
+	HotFraction   float64                    `json:"hot_fraction,omitempty" yaml:"hot_fraction,omitempty"`
+	Deletes       int64                      `json:"deletes"`
+	Populate      int64                      `json:"populate,omitempty"`
+	Checkpoint    string                     `json:"checkpoint,omitempty"`
+	UDFs          int64                      `json:"udfs,omitempty"`
+	UDF           UDFOptions                 `json:"udf,omitempty"`
+	Operates      int64                      `json:"operates,omitempty"`
+	OperateMix    OperateMix                 `json:"operate_mix,omitempty" yaml:"operate_mix,omitempty"`
+	ListOps       int64                      `json:"list_ops,omitempty" yaml:"list_ops,omitempty"`
+	ListMix       ListMix                    `json:"list_mix,omitempty" yaml:"list_mix,omitempty"`
+	MapOps        int64                      `json:"map_ops,omitempty" yaml:"map_ops,omitempty"`
+	MapMix        MapMix                     `json:"map_mix,omitempty" yaml:"map_mix,omitempty"`
+	Grows         int64                      `json:"grows,omitempty"`
+	Growth        GrowthOptions              `json:"growth,omitempty"`
+	Queries       int64                      `json:"queries"`
+	Scans         int64                      `json:"scans"`
+	Workers       int64                      `json:"workers,omitempty"`
+	Mix           map[string]int64           `json:"mix,omitempty"`
+	BatchReads    int64                      `json:"batch_reads,omitempty" yaml:"batch_reads,omitempty"`
+	BatchSize     IntegerConstraints         `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`
+	DurableDelete bool                       `json:"durable_delete,omitempty" yaml:"durable_delete,omitempty"`
+	QueryRange    int64                      `json:"query_range,omitempty" yaml:"query_range,omitempty"`
+	Scan          ScanOptions                `json:"scan,omitempty"`
+	TPS           map[string]int64           `json:"tps,omitempty"`
+	OpenLoop      bool                       `json:"open_loop,omitempty" yaml:"open_loop,omitempty"`
+	Duration      time.Duration              `json:"duration,omitempty"`
+	MaxOps        int64                      `json:"max_ops,omitempty" yaml:"max_ops,omitempty"`
+	Limits        map[string]int64           `json:"limits,omitempty"`
+	Profile       ProfileOptions             `json:"profile,omitempty"`
+}
+
+// Bounded tells whether the load model ends by itself: after its duration or
+// its number of operations, or once every operation type it runs has reached
+// its limit. Populate always ends, once every key is written.
+func (l *LoadModel) Bounded() bool {
+
+	if l.Duration > 0 || l.MaxOps > 0 {
+		return true
+	}
+
+	running := map[string]int64{
+		"reads":       l.Reads,
+		"batch_reads": l.BatchReads,
+		"writes":      l.Writes,
+		"cas_writes":  l.CASWrites,
+		"operates":    l.Operates,
+		"list_ops":    l.ListOps,
+		"map_ops":     l.MapOps,
+		"grows":       l.Grows,
+		"udfs":        l.UDFs,
+		"deletes":     l.Deletes,
+		"queries":     l.Queries,
+		"scans":       l.Scans,
+	}
+	if l.Workers > 0 {
+		running = l.Mix
+	}
+
+	for name, n := range running {
+		if n > 0 && name != "populate" && l.Limits[name] <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type PhaseModel struct {
+	Name      string `json:"name"`
+	LoadModel `yaml:",inline"`
+}
+
+type PolicyModel struct {
+	Timeout             time.Duration `json:"timeout,omitempty"`
+	MaxRetries          *int          `json:"max_retries,omitempty" yaml:"max_retries,omitempty"`
+	SleepBetweenRetries time.Duration `json:"sleep_between_retries,omitempty" yaml:"sleep_between_retries,omitempty"`
+	Replica             string        `json:"replica,omitempty"`
+	Consistency         string        `json:"consistency,omitempty"`
+	Commit              string        `json:"commit,omitempty"`
+	RecordExists        string        `json:"record_exists,omitempty" yaml:"record_exists,omitempty"`
+	Generation          string        `json:"generation,omitempty"`
+	SendKey             *bool         `json:"send_key,omitempty" yaml:"send_key,omitempty"`
+}
+
+type PoliciesModel struct {
+	Read   PolicyModel `json:"read,omitempty"`
+	Write  PolicyModel `json:"write,omitempty"`
+	Delete PolicyModel `json:"delete,omitempty"`
+	Batch  PolicyModel `json:"batch,omitempty"`
+	Query  PolicyModel `json:"query,omitempty"`
 }
 
 type HostSpec struct {
@@ -88,9 +303,11 @@ type HostSpec struct {
 }
 
 type Config struct {
-	Hosts     []HostSpec `json:"hosts" yaml:"hosts"`
-	LoadModel LoadModel  `json:"load" yaml:"load"`
-	DataModel DataModel  `json:"data" yaml:"data"`
+	Hosts     []HostSpec    `json:"hosts" yaml:"hosts"`
+	LoadModel LoadModel     `json:"load" yaml:"load"`
+	DataModel DataModel     `json:"data" yaml:"data"`
+	Policies  PoliciesModel `json:"policies,omitempty" yaml:"policies,omitempty"`
+	Phases    []PhaseModel  `json:"phases,omitempty" yaml:"phases,omitempty"`
 }
 
 // ----------------------------------------------------------------------------
@@ -104,6 +321,8 @@ func NewConfig() *Config {
 		Hosts:     []HostSpec{},
 		LoadModel: LoadModel{},
 		DataModel: DataModel{},
+		Policies:  PoliciesModel{},
+		Phases:    []PhaseModel{},
 	}
 }
 
@@ -121,5 +340,54 @@ func (c *Config) Load(filepath string) error {
 		return err
 	}
 
+	// phases do not inherit the load model, each needs its own key space
+	plan := c.Plan()
+	for _, phase := range c.Phases {
+		if phase.Keys <= 0 {
+			return fmt.Errorf("Phase %s has no keys, set its keys, as phases do not use the load model", phase.Name)
+		}
+	}
+
+	// every phase but the last has to end for the next to run
+	for _, phase := range plan[:len(plan)-1] {
+		if !phase.Bounded() {
+			return fmt.Errorf("Phase %s never ends, set its duration, max_ops or limits", phase.Name)
+		}
+	}
+
+	for _, phase := range plan {
+		if err = phase.TTLs.Check(); err != nil {
+			if phase.Name != "" {
+				return fmt.Errorf("Invalid ttls in phase %s: %s", phase.Name, err.Error())
+			}
+			return fmt.Errorf("Invalid ttls: %s", err.Error())
+		}
+	}
+
+	if c.DataModel.Keys.Template != "" {
+		c.DataModel.Keys.template, err = ParseKeyTemplate(c.DataModel.Keys.Template)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// Plan returns the phases to run in order. A config without phases runs its
+// load model as a single, unnamed phase.
+func (c *Config) Plan() []*PhaseModel {
+
+	if len(c.Phases) == 0 {
+		return []*PhaseModel{{Name: "", LoadModel: c.LoadModel}}
+	}
+
+	plan := make([]*PhaseModel, len(c.Phases))
+	for i := range c.Phases {
+		plan[i] = &c.Phases[i]
+		if plan[i].Name == "" {
+			plan[i].Name = fmt.Sprintf("phase-%d", i+1)
+		}
+	}
+	return plan
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadModelBounded(t *testing.T) {
+	tests := []struct {
+		name    string
+		load    LoadModel
+		bounded bool
+	}{
+		{"nothing running", LoadModel{}, true},
+		{"unlimited", LoadModel{Reads: 8}, false},
+		{"duration", LoadModel{Reads: 8, Duration: time.Minute}, true},
+		{"max ops", LoadModel{Reads: 8, MaxOps: 1000}, true},
+		{"limited", LoadModel{Reads: 8, Limits: map[string]int64{"reads": 1000}}, true},
+		{"partly limited", LoadModel{Reads: 8, Writes: 8, Limits: map[string]int64{"reads": 1000}}, false},
+		{"limit of idle type", LoadModel{Writes: 8, Limits: map[string]int64{"reads": 1000}}, false},
+		{"populate", LoadModel{Populate: 32}, true},
+		{"populate and unlimited", LoadModel{Populate: 32, Deletes: 4}, false},
+		{"mix limited", LoadModel{Workers: 8, Mix: map[string]int64{"reads": 9, "writes": 1}, Limits: map[string]int64{"reads": 10, "writes": 10}}, true},
+		{"mix partly limited", LoadModel{Workers: 8, Mix: map[string]int64{"reads": 9, "writes": 1}, Limits: map[string]int64{"reads": 10}}, false},
+		{"mix zero weight", LoadModel{Workers: 8, Mix: map[string]int64{"reads": 9, "writes": 0}, Limits: map[string]int64{"reads": 10}}, true},
+		{"mix ignores pools", LoadModel{Workers: 8, Reads: 8, Mix: map[string]int64{"writes": 1}, Limits: map[string]int64{"writes": 10}}, true},
+		{"mix populate", LoadModel{Workers: 8, Mix: map[string]int64{"populate": 1}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if b := test.load.Bounded(); b != test.bounded {
+				t.Errorf("bounded = %v, want %v", b, test.bounded)
+			}
+		})
+	}
+}
+
+func TestConfigLoadPhases(t *testing.T) {
+	tests := []struct {
+		name   string
+		phases string
+		err    string // "" when the config loads
+	}{
+		{"none", "", ""},
+		{"bounded", "- {keys: 10, reads: 4, duration: 1m}\n- {keys: 10, reads: 4}", ""},
+		{"unbounded", "- {name: warm, keys: 10, reads: 4}\n- {keys: 10, reads: 4}", "Phase warm never ends"},
+		{"no keys", "- {keys: 10, populate: 4}\n- {reads: 4, max_ops: 10}", "Phase phase-2 has no keys"},
+		{"invalid ttls", "- {name: hot, keys: 10, reads: 4, ttls: {min: 60}}", "Invalid ttls in phase hot"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw := "load:\n  keys: 10\n  reads: 4\n"
+			if test.phases != "" {
+				raw += "phases:\n" + test.phases + "\n"
+			}
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := ioutil.WriteFile(path, []byte(raw), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := NewConfig().Load(path)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("error %v, want none", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Fatalf("error %v, want one containing %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aerospike/aerospike-client-go"
+)
+
+// NewKeyGenerator chooses keys from the key space by the configured
+// distribution, uniformly when none is set, or replays them from a trace of
+// the data model's keys.
+func NewKeyGenerator(keys KeySpace, data *DataModel, d *KeyDistribution) KeyGenerator {
+	if d.Offset > 0 || (d.Span > 0 && d.Span < 1) {
+		keys = NewKeyWindow(keys, d.Offset, d.Span)
+	}
+
+	switch strings.ToLower(d.Type) {
+	case "", "uniform":
+		return &UniformKeyGenerator{Keys: keys}
+	case "zipfian":
+		return NewZipfianKeyGenerator(keys, d.Theta)
+	case "hotspot":
+		return NewHotspotKeyGenerator(keys, d.HotOps, d.HotKeys)
+	case "gaussian":
+		return NewGaussianKeyGenerator(keys, d.Mean, d.Deviation)
+	case "sequential":
+		return &SequentialKeyGenerator{Keys: keys}
+	case "exponential":
+		return NewExponentialKeyGenerator(keys, d.Mean)
+	case "latest":
+		return NewLatestKeyGenerator(keys, d.Theta)
+	case "drifting":
+		return NewDriftingKeyGenerator(keys, d)
+	case "trace":
+		g, err := NewTraceKeyGenerator(d.File, d.Format, d.Loop, &data.Keys)
+		if err != nil {
+			logError("Not able to open trace %s: %s", d.File, err.Error())
+			return &TraceKeyGenerator{Path: d.File, ended: 1}
+		}
+		return g
+	}
+	logWarn("Unknown key distribution %s, using uniform", d.Type)
+	return &UniformKeyGenerator{Keys: keys}
+}
+
+// KeyWindow is a part of a key space, starting at a fraction (offset) of it
+// and covering another fraction (span) of it.
+type KeyWindow struct {
+	Keys   KeySpace
+	Offset float64
+	Span   float64
+}
+
+func NewKeyWindow(keys KeySpace, offset float64, span float64) *KeyWindow {
+	if offset < 0 || offset >= 1 {
+		offset = 0
+	}
+	if span <= 0 || offset+span > 1 {
+		span = 1 - offset
+	}
+	return &KeyWindow{
+		Keys:   keys,
+		Offset: offset,
+		Span:   span,
+	}
+}
+
+func (w *KeyWindow) KeyCount() int64 {
+	n := int64(float64(w.Keys.KeyCount()) * w.Span)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (w *KeyWindow) KeyAt(i int64) *aerospike.Key {
+	return w.Keys.KeyAt(int64(float64(w.Keys.KeyCount())*w.Offset) + i)
+}
+
+// UniformKeyGenerator chooses every key with the same probability.
+type UniformKeyGenerator struct {
+	Keys KeySpace
+}
+
+func (g *UniformKeyGenerator) GetKey() *aerospike.Key {
+	n := g.Keys.KeyCount()
+	if n <= 0 {
+		return nil
+	}
+	return g.Keys.KeyAt(rand.Int63() % n)
+}
+
+var (
+	ZETA_EXACT int64 = 1000
+)
+
+// ZipfianKeyGenerator chooses keys following a zipfian distribution, the
+// lowest indexes being the most popular. Theta is the skew, between 0 and 1
+// exclusive, as in YCSB (Gray et al, "Quickly Generating Billion-Record
+// Synthetic Databases").
+type ZipfianKeyGenerator struct {
+	Keys   KeySpace
+	Theta  float64
+	alpha  float64
+	zeta2  float64
+	head   float64
+	params atomic.Value
+}
+
+// zipfianParams are the constants of the distribution over n keys.
+type zipfianParams struct {
+	n     int64
+	zetan float64
+	eta   float64
+}
+
+func NewZipfianKeyGenerator(keys KeySpace, theta float64) *ZipfianKeyGenerator {
+	if theta <= 0 || theta >= 1 {
+		theta = 0.99
+	}
+
+	g := &ZipfianKeyGenerator{
+		Keys:  keys,
+		Theta: theta,
+		alpha: 1 / (1 - theta),
+		zeta2: zetaExact(2, theta),
+		head:  zetaExact(ZETA_EXACT, theta),
+	}
+	g.params.Store(g.paramsFor(keys.KeyCount()))
+	return g
+}
+
+// zetaExact sums 1/i^theta for i from 1 to n.
+func zetaExact(n int64, theta float64) float64 {
+	var sum float64 = 0
+	for i := int64(1); i <= n; i++ {
+		sum += 1 / math.Pow(float64(i), theta)
+	}
+	return sum
+}
+
+// zeta sums 1/i^theta for i from 1 to n, exactly over the first ZETA_EXACT
+// terms (already summed in `head`), and past them approximated by the
+// Euler-Maclaurin formula, so it takes constant time for large n.
+func (g *ZipfianKeyGenerator) zeta(n int64) float64 {
+	if n <= ZETA_EXACT {
+		return zetaExact(n, g.Theta)
+	}
+
+	t := g.Theta
+	a, b := float64(ZETA_EXACT), float64(n)
+	f := func(x float64) float64 { return math.Pow(x, -t) }
+	df := func(x float64) float64 { return -t * math.Pow(x, -t-1) }
+
+	return g.head + (math.Pow(b, 1-t)-math.Pow(a, 1-t))/(1-t) + (f(b)-f(a))/2 + (df(b)-df(a))/12
+}
+
+func (g *ZipfianKeyGenerator) paramsFor(n int64) *zipfianParams {
+	p := &zipfianParams{n: n, zetan: g.zeta(n)}
+	p.eta = (1 - math.Pow(2/float64(n), 1-g.Theta)) / (1 - g.zeta2/p.zetan)
+	return p
+}
+
+func (g *ZipfianKeyGenerator) GetKey() *aerospike.Key {
+	n := g.Keys.KeyCount()
+	if n <= 0 {
+		return nil
+	}
+	return g.Keys.KeyAt(g.next(n))
+}
+
+// next draws an index below n, the constants of the distribution being
+// recomputed when n changes.
+func (g *ZipfianKeyGenerator) next(n int64) int64 {
+	p := g.params.Load().(*zipfianParams)
+	if p.n != n {
+		p = g.paramsFor(n)
+		g.params.Store(p)
+	}
+
+	u := rand.Float64()
+	uz := u * p.zetan
+
+	var i int64
+	switch {
+	case uz < 1:
+		i = 0
+	case uz < 1+math.Pow(0.5, g.Theta):
+		i = 1
+	default:
+		i = int64(float64(n) * math.Pow(p.eta*u-p.eta+1, g.alpha))
+	}
+	if i >= n {
+		i = n - 1
+	}
+	return i
+}
+
+// LatestKeyGenerator favors the most recently written keys, like YCSB's
+// latest distribution, the distance back from the latest key being zipfian.
+// Its inserts extend the key space past the keys already there, which it
+// takes to be written, the latest key advancing as their writes are
+// acknowledged.
+type LatestKeyGenerator struct {
+	Keys   KeySpace
+	Latest int64
+	next   int64
+	zipf   *ZipfianKeyGenerator
+}
+
+func NewLatestKeyGenerator(keys KeySpace, theta float64) *LatestKeyGenerator {
+	return &LatestKeyGenerator{
+		Keys:   keys,
+		Latest: keys.KeyCount(),
+		next:   keys.KeyCount(),
+		zipf:   NewZipfianKeyGenerator(keys, theta),
+	}
+}
+
+func (g *LatestKeyGenerator) GetKey() *aerospike.Key {
+	n := atomic.LoadInt64(&g.Latest)
+	if n <= 0 {
+		return nil
+	}
+	return g.Keys.KeyAt(n - 1 - g.zipf.next(n))
+}
+
+// Inserts returns a generator of new keys, each past the latest so far.
+func (g *LatestKeyGenerator) Inserts() KeyGenerator {
+	return &latestInserts{
+		latest:  g,
+		pending: map[*aerospike.Key]int64{},
+	}
+}
+
+// KeyAcknowledger is a key generator told whether the writes of its keys
+// succeeded.
+type KeyAcknowledger interface {
+	Acknowledge(key *aerospike.Key, written bool)
+}
+
+type latestInserts struct {
+	latest  *LatestKeyGenerator
+	pending map[*aerospike.Key]int64
+	mutex   sync.Mutex
+}
+
+func (g *latestInserts) GetKey() *aerospike.Key {
+	i := atomic.AddInt64(&g.latest.next, 1) - 1
+	k := g.latest.Keys.KeyAt(i)
+	if k != nil {
+		g.mutex.Lock()
+		g.pending[k] = i
+		g.mutex.Unlock()
+	}
+	return k
+}
+
+// Acknowledge advances the latest key to the highest written so far.
+func (g *latestInserts) Acknowledge(key *aerospike.Key, written bool) {
+	g.mutex.Lock()
+	i, ok := g.pending[key]
+	delete(g.pending, key)
+	g.mutex.Unlock()
+
+	if !ok || !written {
+		return
+	}
+	for {
+		n := atomic.LoadInt64(&g.latest.Latest)
+		if i < n || atomic.CompareAndSwapInt64(&g.latest.Latest, n, i+1) {
+			return
+		}
+	}
+}
+
+// HotspotKeyGenerator sends a fraction of the operations (Ops) to a fraction
+// of the key space (Keys) at its start, and the rest to the other keys,
+// uniformly within each.
+type HotspotKeyGenerator struct {
+	Keys    KeySpace
+	HotOps  float64
+	HotKeys float64
+}
+
+func NewHotspotKeyGenerator(keys KeySpace, ops float64, fraction float64) *HotspotKeyGenerator {
+	if ops <= 0 || ops > 1 {
+		ops = 0.8
+	}
+	if fraction <= 0 || fraction > 1 {
+		fraction = 0.2
+	}
+	return &HotspotKeyGenerator{
+		Keys:    keys,
+		HotOps:  ops,
+		HotKeys: fraction,
+	}
+}
+
+func (g *HotspotKeyGenerator) GetKey() *aerospike.Key {
+	n := g.Keys.KeyCount()
+	if n <= 0 {
+		return nil
+	}
+	return g.Keys.KeyAt(g.index(n))
+}
+
+// index draws an index below n, in the hot set at the start of the keys for
+// a fraction of the operations, or else in the rest.
+func (g *HotspotKeyGenerator) index(n int64) int64 {
+	hot := int64(float64(n) * g.HotKeys)
+	if hot < 1 {
+		hot = 1
+	}
+
+	if hot >= n {
+		return rand.Int63() % n
+	}
+	if rand.Float64() < g.HotOps {
+		return rand.Int63() % hot
+	}
+	return hot + rand.Int63()%(n-hot)
+}
+
+// DriftingKeyGenerator is a hotspot that moves through the key space, every
+// interval sliding by a step (a fraction of the key space), or jumping to
+// somewhere else entirely.
+type DriftingKeyGenerator struct {
+	HotspotKeyGenerator
+	Interval time.Duration
+	Step     float64
+	Jump     bool
+	start    time.Time
+}
+
+func NewDriftingKeyGenerator(keys KeySpace, d *KeyDistribution) *DriftingKeyGenerator {
+	g := &DriftingKeyGenerator{
+		HotspotKeyGenerator: *NewHotspotKeyGenerator(keys, d.HotOps, d.HotKeys),
+		Interval:            d.Interval,
+		Step:                d.Step,
+		Jump:                d.Jump,
+		start:               time.Now(),
+	}
+	if g.Interval <= 0 {
+		g.Interval = 10 * time.Second
+	}
+	if g.Step <= 0 {
+		g.Step = 0.01
+	}
+	return g
+}
+
+// offset is where the hot set starts, after the intervals elapsed so far.
+func (g *DriftingKeyGenerator) offset(n int64) int64 {
+	k := int64(time.Since(g.start) / g.Interval)
+	if g.Jump {
+		return int64(mix64(uint64(k)) % uint64(n))
+	}
+	return int64(float64(k)*g.Step*float64(n)) % n
+}
+
+// mix64 scrambles the bits of x (splitmix64 finalizer).
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (g *DriftingKeyGenerator) GetKey() *aerospike.Key {
+	n := g.Keys.KeyCount()
+	if n <= 0 {
+		return nil
+	}
+
+	// the hot set wraps around the end of the key space
+	return g.Keys.KeyAt((g.offset(n) + g.index(n)) % n)
+}
+
+// GaussianKeyGenerator chooses keys normally distributed around the mean,
+// with the mean and deviation given as fractions of the key space.
+type GaussianKeyGenerator struct {
+	Keys      KeySpace
+	Mean      float64
+	Deviation float64
+}
+
+func NewGaussianKeyGenerator(keys KeySpace, mean float64, deviation float64) *GaussianKeyGenerator {
+	if mean <= 0 || mean >= 1 {
+		mean = 0.5
+	}
+	if deviation <= 0 {
+		deviation = 0.1
+	}
+	return &GaussianKeyGenerator{
+		Keys:      keys,
+		Mean:      mean,
+		Deviation: deviation,
+	}
+}
+
+func (g *GaussianKeyGenerator) GetKey() *aerospike.Key {
+	n := g.Keys.KeyCount()
+	if n <= 0 {
+		return nil
+	}
+
+	// draw again when outside the key space
+	for {
+		i := int64(math.Floor((rand.NormFloat64()*g.Deviation + g.Mean) * float64(n)))
+		if i >= 0 && i < n {
+			return g.Keys.KeyAt(i)
+		}
+	}
+}
+
+// SequentialKeyGenerator walks through the key space in order, wrapping
+// around at its end.
+type SequentialKeyGenerator struct {
+	Keys KeySpace
+	next int64
+}
+
+func (g *SequentialKeyGenerator) GetKey() *aerospike.Key {
+	n := g.Keys.KeyCount()
+	if n <= 0 {
+		return nil
+	}
+	i := atomic.AddInt64(&g.next, 1) - 1
+	return g.Keys.KeyAt(i % n)
+}
+
+// ExponentialKeyGenerator chooses keys exponentially distributed from the
+// start of the key space, with the mean given as a fraction of it.
+type ExponentialKeyGenerator struct {
+	Keys KeySpace
+	Mean float64
+}
+
+func NewExponentialKeyGenerator(keys KeySpace, mean float64) *ExponentialKeyGenerator {
+	if mean <= 0 {
+		mean = 0.1
+	}
+	return &ExponentialKeyGenerator{
+		Keys: keys,
+		Mean: mean,
+	}
+}
+
+func (g *ExponentialKeyGenerator) GetKey() *aerospike.Key {
+	n := g.Keys.KeyCount()
+	if n <= 0 {
+		return nil
+	}
+
+	// draw again when outside the key space
+	for {
+		i := int64(rand.ExpFloat64() * g.Mean * float64(n))
+		if i >= 0 && i < n {
+			return g.Keys.KeyAt(i)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aerospike/aerospike-client-go"
+)
+
+// indexSpace is a key space recording the indexes drawn from it.
+type indexSpace struct {
+	count int64
+	drawn []int64
+	mutex sync.Mutex
+}
+
+func (s *indexSpace) KeyCount() int64 {
+	return s.count
+}
+
+func (s *indexSpace) KeyAt(i int64) *aerospike.Key {
+	s.mutex.Lock()
+	s.drawn = append(s.drawn, i)
+	s.mutex.Unlock()
+
+	k, _ := aerospike.NewKey("test", "test", i)
+	return k
+}
+
+// checkBounds draws keys, and checks their indexes are within [min, max).
+func checkBounds(t *testing.T, g KeyGenerator, s *indexSpace, draws int, min int64, max int64) {
+	t.Helper()
+
+	for i := 0; i < draws; i++ {
+		g.GetKey()
+	}
+	if len(s.drawn) != draws {
+		t.Fatalf("drew %d keys, want %d", len(s.drawn), draws)
+	}
+	for _, i := range s.drawn {
+		if i < min || i >= max {
+			t.Fatalf("drew index %d, outside [%d, %d)", i, min, max)
+		}
+	}
+}
+
+func TestKeyDistributionBounds(t *testing.T) {
+	tests := []struct {
+		name         string
+		distribution KeyDistribution
+		count        int64
+	}{
+		{"uniform", KeyDistribution{}, 1000},
+		{"uniform single key", KeyDistribution{Type: "uniform"}, 1},
+		{"zipfian", KeyDistribution{Type: "zipfian", Theta: 0.99}, 1000},
+		{"zipfian small", KeyDistribution{Type: "zipfian"}, 2},
+		{"zipfian approximated", KeyDistribution{Type: "zipfian", Theta: 0.5}, 10000000},
+		{"zipfian invalid theta", KeyDistribution{Type: "zipfian", Theta: 2}, 1000},
+		{"hotspot", KeyDistribution{Type: "hotspot", HotOps: 0.9, HotKeys: 0.1}, 1000},
+		{"hotspot tiny", KeyDistribution{Type: "hotspot", HotKeys: 0.001}, 10},
+		{"gaussian", KeyDistribution{Type: "gaussian", Mean: 0.9, Deviation: 0.5}, 1000},
+		{"sequential", KeyDistribution{Type: "sequential"}, 7},
+		{"exponential", KeyDistribution{Type: "exponential", Mean: 2}, 1000},
+		{"unknown", KeyDistribution{Type: "pareto"}, 1000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &indexSpace{count: test.count}
+			g := NewKeyGenerator(s, &DataModel{}, &test.distribution)
+			checkBounds(t, g, s, 2000, 0, test.count)
+		})
+	}
+}
+
+func TestKeyWindowBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		offset   float64
+		span     float64
+		min, max int64
+	}{
+		{"start", 0, 0.25, 0, 250},
+		{"middle", 0.5, 0.1, 500, 600},
+		{"end", 0.9, 0.5, 900, 1000},
+		{"invalid offset", 1.5, 0.5, 0, 500},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &indexSpace{count: 1000}
+			d := &KeyDistribution{Offset: test.offset, Span: test.span}
+			checkBounds(t, NewKeyGenerator(s, &DataModel{}, d), s, 2000, test.min, test.max)
+		})
+	}
+}
+
+func TestZeta(t *testing.T) {
+	tests := []struct {
+		theta float64
+		n     int64
+	}{
+		{0.99, 1},
+		{0.99, ZETA_EXACT},
+		{0.99, ZETA_EXACT + 1},
+		{0.99, 1000000},
+		{0.5, 1000000},
+		{0.1, 1000000},
+	}
+
+	for _, test := range tests {
+		g := NewZipfianKeyGenerator(&indexSpace{count: test.n}, test.theta)
+		got, want := g.zeta(test.n), zetaExact(test.n, test.theta)
+		if math.Abs(got-want) > 1e-9*want {
+			t.Errorf("zeta(%d, %v) = %v, want %v", test.n, test.theta, got, want)
+		}
+	}
+}
+
+func TestLatestBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int64
+		inserts []bool // whether each insert is written, acknowledged last first
+		latest  int64
+	}{
+		{"no inserts", 100, nil, 100},
+		{"written", 100, []bool{true, true, true}, 103},
+		{"not written", 100, []bool{false, false}, 100},
+		{"highest written", 100, []bool{false, false, true, false}, 103},
+		{"empty", 0, []bool{true}, 1},
+		{"empty not written", 0, []bool{false}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &indexSpace{count: test.count}
+			g := NewLatestKeyGenerator(s, 0.99)
+			inserts := g.Inserts()
+
+			keys := make([]*aerospike.Key, len(test.inserts))
+			for i := range keys {
+				keys[i] = inserts.GetKey()
+			}
+			for i := len(keys) - 1; i >= 0; i-- {
+				inserts.(KeyAcknowledger).Acknowledge(keys[i], test.inserts[i])
+			}
+
+			if g.Latest != test.latest {
+				t.Fatalf("latest = %d, want %d", g.Latest, test.latest)
+			}
+
+			s.drawn = nil
+			if test.latest == 0 {
+				if g.GetKey() != nil {
+					t.Fatalf("drew a key with none written")
+				}
+				return
+			}
+			checkBounds(t, g, s, 2000, 0, test.latest)
+		})
+	}
+}
+
+func TestDriftingBounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		step      float64
+		jump      bool
+		intervals int64
+		offset    int64 // where the hot set starts, -1 when jumping
+	}{
+		{"start", 0.1, false, 0, 0},
+		{"slid", 0.1, false, 3, 300},
+		{"wrapped", 0.3, false, 4, 200},
+		{"default step", 0, false, 5, 50},
+		{"jumped", 0, true, 7, -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &indexSpace{count: 1000}
+			g := NewDriftingKeyGenerator(s, &KeyDistribution{
+				Type:     "drifting",
+				HotOps:   1,
+				HotKeys:  0.1,
+				Interval: time.Hour,
+				Step:     test.step,
+				Jump:     test.jump,
+			})
+			g.start = time.Now().Add(-time.Duration(test.intervals)*time.Hour - time.Minute)
+
+			offset := g.offset(s.count)
+			if offset < 0 || offset >= s.count {
+				t.Fatalf("offset %d outside the key space", offset)
+			}
+			if test.offset >= 0 && offset != test.offset {
+				t.Fatalf("offset = %d, want %d", offset, test.offset)
+			}
+
+			// every operation goes to the hot set, wrapping around the end
+			checkBounds(t, g, s, 2000, 0, s.count)
+			for _, i := range s.drawn {
+				if (i-offset+s.count)%s.count >= 100 {
+					t.Fatalf("drew index %d, outside the hot set from %d", i, offset)
+				}
+			}
+		})
+	}
+}
@@ -1,33 +1,99 @@
 package main
 
 import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/aerospike/aerospike-client-go"
+	"github.com/aerospike/aerospike-client-go/types"
 )
 
 type Executor struct {
-	Client  *aerospike.Client
-	Load    *LoadModel
-	Keys    KeyGenerator
-	Records RecordGenerator
-	halt    chan bool
+	Client   *aerospike.Client
+	Load     *LoadModel
+	Data     *DataModel
+	Policies *PoliciesModel
+	Space    KeySpace
+	Keys     KeyGenerator
+	Records  RecordGenerator
+	halt     chan bool
+	count    int64
+	issued   int64
+	populate *Populator
+	verifier *TTLVerifier
+	latest   *LatestKeyGenerator
+	traces   map[string]*TraceKeyGenerator
+	skipped  map[string]bool
+	stop     sync.Once
 }
 
-func NewExecutor(client *aerospike.Client, load *LoadModel, keys KeyGenerator, records RecordGenerator) *Executor {
+func NewExecutor(client *aerospike.Client, load *LoadModel, data *DataModel, policies *PoliciesModel, keys KeySpace, records RecordGenerator) *Executor {
 	return &Executor{
-		Client:  client,
-		Load:    load,
-		Keys:    keys,
-		Records: records,
-		halt:    make(chan bool),
+		Client:   client,
+		Load:     load,
+		Data:     data,
+		Policies: policies,
+		Space:    keys,
+		Records:  records,
+		halt:     make(chan bool),
+		traces:   map[string]*TraceKeyGenerator{},
+		skipped:  map[string]bool{},
+	}
+}
+
+// keysFor returns the key generator of an operation type, with its own
+// distribution when one is configured, or else the shared generator. The
+// latest distribution is shared by every operation type using it, writes
+// extending the keys it reads from. Operation types replaying a trace are
+// noted, to stop at its end.
+func (e *Executor) keysFor(name string) KeyGenerator {
+	d, own := e.Load.Distributions[name]
+	if !own {
+		d = e.Load.Distribution
+	}
+
+	if strings.ToLower(d.Type) == "latest" {
+		if e.latest == nil {
+			e.latest = NewLatestKeyGenerator(e.Space, d.Theta)
+		}
+		if name == "writes" {
+			return e.latest.Inserts()
+		}
+		return e.latest
+	}
+
+	var g KeyGenerator
+	if own {
+		g = NewKeyGenerator(e.Space, e.Data, &d)
+	} else {
+		g = e.sharedKeys()
 	}
+	if t, ok := g.(*TraceKeyGenerator); ok {
+		e.traces[name] = t
+	}
+	return g
+}
+
+// sharedKeys returns the generator shared by operation types without their
+// own distribution, built on first use.
+func (e *Executor) sharedKeys() KeyGenerator {
+	if e.Keys == nil {
+		e.Keys = NewKeyGenerator(e.Space, e.Data, &e.Load.Distribution)
+	}
+	return e.Keys
 }
 
+// Stop ends Run, which stops the workloads and saves the populate checkpoint
+// before returning. It is safe to call many times, and once Run returned.
 func (e *Executor) Stop() {
-	e.halt <- true
-	<-e.halt
-	e.Client.Close()
-	e.Client = nil
-	logInfo("Executor stopped.")
+	e.stop.Do(func() {
+		close(e.halt)
+	})
 }
 
 func executeOp(halt chan bool, op func()) {
@@ -41,38 +107,572 @@ func executeOp(halt chan bool, op func()) {
 	}
 }
 
+// executeOpenLoop dispatches an operation at the limiter's rate on a fixed
+// schedule, regardless of whether earlier operations have completed. When it
+// falls behind, it dispatches immediately until it is back on schedule.
+// Operations in flight are tracked by `inflight`, and capped at `max`:
+// dispatches due while the cap is reached are dropped, and counted.
+func executeOpenLoop(halt chan bool, limiter *Limiter, op func(), inflight *sync.WaitGroup, max int64) {
+
+	next := time.Now()
+	slots := make(chan bool, max)
+
+	for {
+		select {
+		case <-halt:
+			return
+		default:
+			tps := limiter.Rate()
+			if tps <= 0 {
+				time.Sleep(LIMITER_PAUSE)
+				next = time.Now()
+				continue
+			}
+			next = next.Add(time.Second / time.Duration(tps))
+			if wait := next.Sub(time.Now()); wait > 0 {
+				time.Sleep(wait)
+			}
+
+			select {
+			case slots <- true:
+				inflight.Add(1)
+				go func() {
+					defer func() {
+						<-slots
+						inflight.Done()
+					}()
+					op()
+				}()
+			default:
+				atomic.AddUint64(&CURRENT_STATS.Dropped, 1)
+			}
+		}
+	}
+}
+
+// Workload is a pool of workers running the same operation. Its name is the
+// load model setting it is sized by. Only the first `active` workers run,
+// the others stay parked until a profile activates them. The operation
+// reports whether it issued a request, which is all that limits count.
+type Workload struct {
+	Name    string
+	Workers int64
+	Op      func() bool
+	TPS     int64
+	Limit   int64
+	count   int64
+	issued  int64
+	active  int64
+	limiter *Limiter
+	run     func()
+	halt    chan bool
+	once    sync.Once
+}
+
+func (w *Workload) Stopped() bool {
+	select {
+	case <-w.halt:
+		return true
+	default:
+		return false
+	}
+}
+
+// gate wraps the operation of the i-th worker, so it only runs while the
+// worker is active.
+func (w *Workload) gate(i int64, op func()) func() {
+	return func() {
+		if i < atomic.LoadInt64(&w.active) {
+			op()
+		} else {
+			time.Sleep(LIMITER_PAUSE)
+		}
+	}
+}
+
+// Stop halts all the workers of the workload. It is safe to call many times.
+func (w *Workload) Stop() {
+	w.once.Do(func() {
+		close(w.halt)
+	})
+}
+
+func (e *Executor) workloads() []*Workload {
+
+	readPolicy := e.Policies.Read.ReadPolicy()
+	batchPolicy := e.Policies.Batch.ReadPolicy()
+	writePolicy := e.Policies.Write.WritePolicy(e.Load.TTL)
+	queryPolicy := e.Policies.Query.QueryPolicy()
+
+	deletePolicy := e.Policies.Delete.WritePolicy(0)
+	deletePolicy.DurableDelete = e.Load.DurableDelete
+
+	// a sample of writes have their expiration verified
+	if e.Load.TTLVerify.Sample > 0 {
+		e.verifier = NewTTLVerifier(e.Client, &e.Load.TTLVerify)
+	}
+
+	// every write draws its ttl from the distribution, if any
+	ttls := TTLGenerator(&e.Load.TTLs)
+
+	// reads of bins need bins to read
+	readMix := e.Load.ReadMix
+	if readMix.Bins > 0 {
+		if err := checkBins(e.Data, e.Load.ReadBins); err != nil {
+			if e.Load.Reads > 0 || e.Load.Mix["reads"] > 0 {
+				logWarn("Not reading bins, %s", err.Error())
+			}
+			readMix.Bins = 0
+		}
+	}
+
+	casRetries := CAS_RETRIES
+	if e.Load.CASRetries != nil {
+		casRetries = *e.Load.CASRetries
+	}
+
+	// writes contend on the hot keys, drawn from the key space rather than a
+	// trace, which they would otherwise consume
+	writeKeys := e.keysFor("writes")
+	casKeys := e.keysFor("cas_writes")
+	if e.Load.HotKeys > 0 && e.Load.HotFraction > 0 {
+		hot := NewHotKeyGenerator(&UniformKeyGenerator{Keys: e.Space}, e.Load.HotKeys, e.Load.HotFraction)
+		statHotKeys(hot.Stat)
+		writeKeys = hot.Over(writeKeys)
+		casKeys = hot.Over(casKeys)
+	}
+
+	workloads := []*Workload{
+		{Name: "reads", Workers: e.Load.Reads, Op: ReadGenerator(e.Client, e.keysFor("reads"), readPolicy, &readMix, e.Load.ReadBins)},
+		{Name: "batch_reads", Workers: e.Load.BatchReads, Op: BatchReadGenerator(e.Client, e.keysFor("batch_reads"), &e.Load.BatchSize, batchPolicy)},
+		{Name: "writes", Workers: e.Load.Writes, Op: WriteGenerator(e.Client, writeKeys, e.Records, writePolicy, ttls, e.verifier)},
+		{Name: "cas_writes", Workers: e.Load.CASWrites, Op: CASWriteGenerator(e.Client, casKeys, e.Records, readPolicy, writePolicy, ttls, casRetries)},
+		{Name: "operates", Workers: e.Load.Operates, Op: OperateGenerator(e.Client, e.keysFor("operates"), e.Data, &e.Load.OperateMix, writePolicy, ttls)},
+		{Name: "list_ops", Workers: e.Load.ListOps, Op: ListGenerator(e.Client, e.keysFor("list_ops"), e.Data, &e.Load.ListMix, writePolicy, ttls)},
+		{Name: "map_ops", Workers: e.Load.MapOps, Op: MapGenerator(e.Client, e.keysFor("map_ops"), e.Data, &e.Load.MapMix, writePolicy, ttls)},
+		{Name: "grows", Workers: e.Load.Grows, Op: GrowGenerator(e.Client, e.keysFor("grows"), e.Data, &e.Load.Growth, writePolicy, ttls)},
+		{Name: "udfs", Workers: e.Load.UDFs, Op: UDFGenerator(e.Client, e.keysFor("udfs"), &e.Load.UDF, writePolicy, ttls)},
+		{Name: "deletes", Workers: e.Load.Deletes, Op: DeleteGenerator(e.Client, e.keysFor("deletes"), deletePolicy)},
+		{Name: "queries", Workers: e.Load.Queries, Op: QueryGenerator(e.Client, e.Data, e.Load.QueryRange, queryPolicy)},
+		{Name: "scans", Workers: e.Load.Scans, Op: ScanGenerator(e.Client, e.Data, &e.Load.Scan)},
+	}
+
+	e.checkNames(workloads)
+
+	// skip the workloads with nothing to work on
+	workloads = e.usable(workloads)
+
+	// populate stops once every key is written
+	if e.Load.Populate > 0 {
+		e.populate = NewPopulator(e.Client, e.Data, e.Load.Keys, e.Load.Checkpoint, writePolicy, ttls)
+		if e.populate.Remaining() > 0 {
+			workloads = append([]*Workload{{Name: "populate", Workers: e.Load.Populate, Op: e.populate.Op()}}, workloads...)
+		} else {
+			logInfo("Populate already complete, per checkpoint %s", e.Load.Checkpoint)
+		}
+	}
+
+	for _, w := range workloads {
+		e.initWorkload(w)
+	}
+
+	for _, w := range workloads {
+		w.run = e.limit(w, workloads)
+	}
+
+	return workloads
+}
+
+// checkNames warns about the names in the distributions, target rates and
+// limits of the load model that are not operation types, which are ignored.
+func (e *Executor) checkNames(workloads []*Workload) {
+
+	known := map[string]bool{"populate": true, "mixed": true}
+	for _, w := range workloads {
+		known[w.Name] = true
+	}
+
+	settings := map[string][]string{}
+	for name := range e.Load.Distributions {
+		settings["distributions"] = append(settings["distributions"], name)
+	}
+	for name := range e.Load.TPS {
+		settings["tps"] = append(settings["tps"], name)
+	}
+	for name := range e.Load.Limits {
+		settings["limits"] = append(settings["limits"], name)
+	}
+
+	for _, setting := range []string{"distributions", "tps", "limits"} {
+		names := settings[setting]
+		sort.Strings(names)
+		for _, name := range names {
+			if !known[name] {
+				logWarn("Ignoring %s in %s, which is not an operation type", name, setting)
+			}
+		}
+	}
+}
+
+// usable drops the workloads that cannot run against the data model, which
+// would otherwise spin without doing anything. Those the load model asks for
+// are skipped with a warning.
+func (e *Executor) usable(workloads []*Workload) []*Workload {
+
+	kept := []*Workload{}
+	for _, w := range workloads {
+		reason := ""
+		switch w.Name {
+		case "operates":
+			if operateMix(e.Data, &e.Load.OperateMix).Total <= 0 {
+				reason = "no operation of the operate mix applies to the data model's bins"
+			}
+		case "list_ops":
+			if len(listBins(e.Data)) == 0 {
+				reason = "no list bin in the data model"
+			} else if listMix(&e.Load.ListMix).Total <= 0 {
+				reason = "every weight of list_mix is zero"
+			}
+		case "map_ops":
+			if len(mapBins(e.Data)) == 0 {
+				reason = "no map bin in the data model"
+			} else if mapMix(&e.Load.MapMix).Total <= 0 {
+				reason = "every weight of map_mix is zero"
+			}
+		case "grows":
+			if growthBin(e.Data, e.Load.Growth.Bin) == nil {
+				reason = "no list or map bin for records to grow through"
+			}
+		case "udfs":
+			if e.Load.UDF.Module == "" || e.Load.UDF.Function == "" {
+				reason = "no udf module and function to call"
+			}
+		case "queries":
+			if len(queryBins(e.Data)) == 0 {
+				reason = "no indexed integer or string bin to query"
+			}
+		}
+
+		if reason == "" {
+			kept = append(kept, w)
+			continue
+		}
+
+		e.skipped[w.Name] = true
+		if w.Workers > 0 || e.Load.Mix[w.Name] > 0 {
+			logWarn("Skipping %s, %s", w.Name, reason)
+		}
+	}
+	return kept
+}
+
+func (e *Executor) initWorkload(w *Workload) {
+	w.TPS = e.Load.TPS[w.Name]
+	w.Limit = e.Load.Limits[w.Name]
+	w.active = w.Workers
+	w.halt = make(chan bool)
+	if w.TPS > 0 {
+		w.limiter = NewLimiter(w.TPS)
+	}
+}
+
+// mixed builds a single pool of workers, each picking its next operation from
+// the workloads weighted by the load model's mix, so the configured ratio is
+// the executed one. Operation limits and target rates of the workloads still
+// apply: stopped workloads are dropped from the mix, so picks land on the
+// others, and the pool stops once every workload in the mix has stopped.
+// Workloads out of the mix are stopped, but populate, which keeps its own
+// pool beside the mixed one.
+func (e *Executor) mixed(workloads []*Workload) *Workload {
+
+	members := []*Workload{}
+	ops := []func(){}
+	weights := []int64{}
+
+	names := map[string]bool{}
+	for _, w := range workloads {
+		names[w.Name] = true
+		if weight := e.Load.Mix[w.Name]; weight > 0 {
+			op := w.run
+			if w.limiter != nil {
+				op = w.limiter.Limit(op)
+			}
+			members = append(members, w)
+			ops = append(ops, op)
+			weights = append(weights, weight)
+		} else if w.Name != "populate" {
+			w.Stop()
+		}
+	}
+
+	for name := range e.Load.Mix {
+		if !names[name] && !e.skipped[name] {
+			logWarn("Ignoring %s in the mix, which is not an operation type", name)
+		}
+	}
+	if len(members) == 0 {
+		logWarn("No operation type in the mix, the workers have nothing to run")
+	}
+
+	mix := NewLiveMix(weights...)
+
+	m := &Workload{Name: "mixed", Workers: e.Load.Workers}
+	e.initWorkload(m)
+
+	m.run = func() {
+		i := mix.Pick(func(i int) bool {
+			return members[i].Stopped()
+		})
+		if i < 0 {
+			m.Stop()
+			return
+		}
+		ops[i]()
+	}
+
+	return m
+}
+
+// limit wraps a workload's operation to enforce the workload's own operation
+// limit and the load model's global one. Each call reserves its place in the
+// count before running, so limits are never overshot, and gives it back when
+// the operation issued nothing, so only requests sent count. Calls finding
+// every place reserved by operations in flight wait for their outcome; the
+// operation issuing the last request stops the workloads.
+func (e *Executor) limit(w *Workload, all []*Workload) func() {
+	return func() {
+		if w.Limit > 0 && atomic.AddInt64(&w.count, 1) > w.Limit {
+			atomic.AddInt64(&w.count, -1)
+			time.Sleep(LIMITER_PAUSE)
+			return
+		}
+
+		if e.Load.MaxOps > 0 && atomic.AddInt64(&e.count, 1) > e.Load.MaxOps {
+			atomic.AddInt64(&e.count, -1)
+			if w.Limit > 0 {
+				atomic.AddInt64(&w.count, -1)
+			}
+			time.Sleep(LIMITER_PAUSE)
+			return
+		}
+
+		if !w.Op() {
+			// nothing to issue for now, such as keys left only in flight
+			if w.Limit > 0 {
+				atomic.AddInt64(&w.count, -1)
+			}
+			if e.Load.MaxOps > 0 {
+				atomic.AddInt64(&e.count, -1)
+			}
+			time.Sleep(LIMITER_PAUSE)
+		} else {
+			if w.Limit > 0 && atomic.AddInt64(&w.issued, 1) == w.Limit {
+				w.Stop()
+			}
+			if e.Load.MaxOps > 0 && atomic.AddInt64(&e.issued, 1) == e.Load.MaxOps {
+				for _, x := range all {
+					x.Stop()
+				}
+			}
+		}
+
+		if w.Name == "populate" && e.populate.Finished() {
+			w.Stop()
+		}
+
+		if t, ok := e.traces[w.Name]; ok && t.Ended() {
+			w.Stop()
+		}
+	}
+}
+
+func indexName(data *DataModel, bin string) string {
+	return fmt.Sprintf("%s_%s_%s_idx", data.Keys.Namespace, data.Keys.Set, bin)
+}
+
+// createIndexes creates a secondary index for every indexed bin of the data
+// model, waiting for each to be built. Indexes that already exist are kept.
+func (e *Executor) createIndexes() {
+
+	policy := aerospike.NewWritePolicy(0, 0)
+
+	for _, b := range e.Data.Bins {
+		if !b.Indexed {
+			continue
+		}
+
+		var indexType aerospike.IndexType
+		if b.Value.Integer != nil {
+			indexType = aerospike.NUMERIC
+		} else if b.Value.String != nil {
+			indexType = aerospike.STRING
+		} else {
+			logWarn("Bin %s cannot be indexed, only integer and string bins are supported", b.Name)
+			continue
+		}
+
+		name := indexName(e.Data, b.Name)
+		task, err := e.Client.CreateIndex(policy, e.Data.Keys.Namespace, e.Data.Keys.Set, name, b.Name, indexType)
+		if err != nil {
+			if t, ok := err.(types.AerospikeError); ok && t.ResultCode() == types.INDEX_FOUND {
+				logInfo("Index %s already exists", name)
+			} else {
+				logError("Not able to create index %s: %s", name, err.Error())
+			}
+			continue
+		}
+
+		if err = <-task.OnComplete(); err != nil {
+			logError("Not able to create index %s: %s", name, err.Error())
+			continue
+		}
+
+		logInfo("Created index %s on bin %s", name, b.Name)
+	}
+}
+
+// registerUDF registers the configured UDF module with the cluster, waiting
+// for it to be available on all nodes.
+func (e *Executor) registerUDF() error {
+
+	module := e.Load.UDF.Module
+	task, err := e.Client.RegisterUDFFromFile(nil, module, filepath.Base(module), aerospike.LUA)
+	if err != nil {
+		return err
+	}
+
+	if err = <-task.OnComplete(); err != nil {
+		return err
+	}
+
+	logInfo("Registered UDF module %s", module)
+	return nil
+}
+
+// Run starts the workloads and blocks until the executor is stopped, or until
+// the load model's duration or operation limits are reached.
 func (e *Executor) Run() {
 
+	// create secondary indexes
+	e.createIndexes()
+
+	// register udf module
+	if e.Load.UDFs > 0 && e.Load.UDF.Module != "" {
+		if err := e.registerUDF(); err != nil {
+			logError("Not able to register UDF module %s: %s", e.Load.UDF.Module, err.Error())
+		}
+	}
+
 	// run load generators
-	haltChannels := []chan bool{}
+	var wg sync.WaitGroup
 
 	var i int64 = 0
 	var o int64 = 0
 
-	if e.Load.Reads > 0 {
-		readOp := ReadGenerator(e.Client, e.Keys)
-		for i = 0; i < e.Load.Reads; i++ {
-			halt := make(chan bool)
-			haltChannels = append(haltChannels, halt)
-			go executeOp(halt, readOp)
+	workloads := e.workloads()
+
+	// per-type pools, or a single pool running the weighted mix, beside
+	// populate unless it is mixed in
+	pools := workloads
+	if e.Load.Workers > 0 {
+		m := e.mixed(workloads)
+		pools = []*Workload{m}
+		for _, w := range workloads {
+			if w.Name == "populate" && e.Load.Mix[w.Name] <= 0 {
+				pools = append(pools, w)
+			}
 		}
-		o += i
+		workloads = append(workloads, m)
 	}
 
-	if e.Load.Writes > 0 {
-		writeOp := WriteGenerator(e.Client, e.Keys, e.Records, e.Load.TTL)
-		for i = 0; i < e.Load.Writes; i++ {
-			halt := make(chan bool)
-			haltChannels = append(haltChannels, halt)
-			go executeOp(halt, writeOp)
+	profile := &e.Load.Profile
+	if profile.Enabled() {
+		applyProfile(profile, pools, 0, e.Load.Duration)
+	}
+
+	for _, w := range pools {
+		if w.Workers <= 0 {
+			w.Stop()
+			continue
+		}
+
+		if e.Load.OpenLoop && w.limiter != nil {
+			wg.Add(1)
+			go func(w *Workload) {
+				defer wg.Done()
+				executeOpenLoop(w.halt, w.limiter, w.run, &wg, w.Workers)
+			}(w)
+			logInfo("Dispatching %s at %d tps, at most %d in flight", w.Name, w.TPS, w.Workers)
+			continue
+		}
+
+		op := w.run
+		if w.limiter != nil {
+			op = w.limiter.Limit(op)
+		}
+
+		for i = 0; i < w.Workers; i++ {
+			wg.Add(1)
+			go func(w *Workload, op func()) {
+				defer wg.Done()
+				executeOp(w.halt, op)
+			}(w, w.gate(i, op))
 		}
 		o += i
 	}
 
-	<-e.halt
+	logInfo("Executor running %d workers", o)
+
+	// finished once every workload has stopped on its own
+	finished := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	var deadline <-chan time.Time = nil
+	if e.Load.Duration > 0 {
+		deadline = time.After(e.Load.Duration)
+	}
+
+	// drive the load profile
+	done := make(chan bool)
+	defer close(done)
+	if profile.Enabled() {
+		go runProfile(profile, pools, e.Load.Duration, done)
+	}
+
+	// verify expirations
+	if e.verifier != nil {
+		go e.verifier.Run(done)
+	}
+
+	// report populate progress
+	if e.populate != nil {
+		go e.populate.Report(logInterval, done)
+	}
+
+	select {
+	case <-e.halt:
+		logInfo("Executor stopped by a signal")
+	case <-deadline:
+		logInfo("Executor reached its duration of %v", e.Load.Duration)
+	case <-finished:
+		logInfo("Executor reached its operation limits")
+	}
+
 	logInfo("Executor stopping...")
-	for _, hc := range haltChannels {
-		hc <- true
+	for _, w := range workloads {
+		w.Stop()
+	}
+	<-finished
+
+	// save the checkpoint before the process may exit
+	if e.populate != nil {
+		e.populate.Save()
+	}
+
+	for _, t := range e.traces {
+		t.Close()
 	}
-	e.halt <- true
 }
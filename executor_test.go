@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecutorLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxOps  int64
+		limits  []int64 // of each workload
+		idle    int64   // every idle-th call issues nothing, 0 when all issue
+		issued  []int64 // requests each workload issued, -1 when any number
+		stopped bool    // whether the workloads stopped on their own
+	}{
+		{"limit", 0, []int64{50}, 0, []int64{50}, true},
+		{"limit with idle calls", 0, []int64{50}, 3, []int64{50}, true},
+		{"limits", 0, []int64{20, 40}, 2, []int64{20, 40}, true},
+		{"max ops", 30, []int64{0, 0}, 0, []int64{-1, -1}, true},
+		{"max ops with idle calls", 30, []int64{0, 0}, 2, []int64{-1, -1}, true},
+		{"limit under max ops", 100, []int64{10, 0}, 2, []int64{10, 90}, true},
+		{"never issued", 10, []int64{10}, 1, []int64{0}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Executor{Load: &LoadModel{MaxOps: test.maxOps}, traces: map[string]*TraceKeyGenerator{}}
+
+			workloads := make([]*Workload, len(test.limits))
+			issued := make([]int64, len(test.limits))
+			for i := range workloads {
+				var calls int64
+				n := &issued[i]
+				workloads[i] = &Workload{Name: "reads", Limit: test.limits[i], halt: make(chan bool)}
+				workloads[i].Op = func() bool {
+					if test.idle > 0 && atomic.AddInt64(&calls, 1)%test.idle == 0 {
+						return false
+					}
+					atomic.AddInt64(n, 1)
+					return true
+				}
+			}
+			for _, w := range workloads {
+				w.run = e.limit(w, workloads)
+			}
+
+			var wg sync.WaitGroup
+			for _, w := range workloads {
+				for i := 0; i < 4; i++ {
+					wg.Add(1)
+					go func(w *Workload) {
+						defer wg.Done()
+						executeOp(w.halt, w.run)
+					}(w)
+				}
+			}
+
+			finished := make(chan bool)
+			go func() {
+				wg.Wait()
+				close(finished)
+			}()
+
+			stopped := true
+			select {
+			case <-finished:
+			case <-time.After(time.Second):
+				stopped = false
+				for _, w := range workloads {
+					w.Stop()
+				}
+				<-finished
+			}
+
+			if stopped != test.stopped {
+				t.Fatalf("stopped = %v, want %v", stopped, test.stopped)
+			}
+			var total int64
+			for i, n := range issued {
+				total += n
+				if test.issued[i] >= 0 && n != test.issued[i] {
+					t.Errorf("workload %d issued %d requests, want %d", i, n, test.issued[i])
+				}
+			}
+			if test.maxOps > 0 && test.stopped && total != test.maxOps {
+				t.Errorf("issued %d requests in all, want %d", total, test.maxOps)
+			}
+		})
+	}
+}
+
+func TestExecutorMixed(t *testing.T) {
+	tests := []struct {
+		name    string
+		mix     map[string]int64
+		stopped []bool // reads, writes, populate
+	}{
+		{"populate beside the mix", map[string]int64{"reads": 1}, []bool{false, true, false}},
+		{"populate in the mix", map[string]int64{"reads": 1, "populate": 1}, []bool{false, true, false}},
+		{"empty mix", nil, []bool{true, true, false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Executor{Load: &LoadModel{Workers: 4, Mix: test.mix}, skipped: map[string]bool{}}
+
+			workloads := []*Workload{{Name: "reads"}, {Name: "writes"}, {Name: "populate"}}
+			for _, w := range workloads {
+				e.initWorkload(w)
+				w.run = func() {}
+			}
+			e.mixed(workloads)
+
+			for i, w := range workloads {
+				if w.Stopped() != test.stopped[i] {
+					t.Errorf("%s stopped = %v, want %v", w.Name, w.Stopped(), test.stopped[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExecutorCheckNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		load     LoadModel
+		warnings []string
+	}{
+		{"none", LoadModel{}, nil},
+		{"known", LoadModel{
+			Distributions: map[string]KeyDistribution{"reads": {}, "populate": {}},
+			TPS:           map[string]int64{"writes": 10, "mixed": 10},
+			Limits:        map[string]int64{"reads": 10},
+		}, nil},
+		{"unknown", LoadModel{
+			Distributions: map[string]KeyDistribution{"read": {}},
+			TPS:           map[string]int64{"writes": 10, "inserts": 10, "gets": 10},
+			Limits:        map[string]int64{"delete": 10},
+		}, []string{"read in distributions", "gets in tps", "inserts in tps", "delete in limits"}},
+	}
+
+	defer log.SetOutput(os.Stderr)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log.SetOutput(&out)
+
+			e := &Executor{Load: &test.load}
+			e.checkNames([]*Workload{{Name: "reads"}, {Name: "writes"}})
+
+			warnings := []string{}
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				if i := strings.Index(line, "Ignoring "); i >= 0 {
+					warnings = append(warnings, strings.TrimSuffix(line[i+len("Ignoring "):], ", which is not an operation type"))
+				}
+			}
+			if strings.Join(warnings, ", ") != strings.Join(test.warnings, ", ") {
+				t.Errorf("warnings = %v, want %v", warnings, test.warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aerospike/aerospike-client-go"
+	"github.com/aerospike/aerospike-client-go/types"
+)
+
+// growthBin finds the bin records grow through: the configured one, or else
+// the first list or map bin of the data model.
+func growthBin(data *DataModel, name string) *BinConstraints {
+	for _, b := range data.Bins {
+		if (name == "" || b.Name == name) && (b.Value.List != nil || b.Value.Map != nil) {
+			return b
+		}
+	}
+	return nil
+}
+
+// growthSize reads the size of the list or map returned by an append or put.
+func growthSize(rec *aerospike.Record, bin string) int64 {
+	if rec == nil {
+		return 0
+	}
+	switch v := rec.Bins[bin].(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
+
+// valueSize estimates the bytes a value takes once stored, much like its
+// msgpack encoding in a list or map.
+func valueSize(v interface{}) int64 {
+	switch x := v.(type) {
+	case nil:
+		return 1
+	case string:
+		return int64(len(x)) + 5
+	case []byte:
+		return int64(len(x)) + 5
+	case []interface{}:
+		var n int64 = 5
+		for _, e := range x {
+			n += valueSize(e)
+		}
+		return n
+	case map[string]interface{}:
+		var n int64 = 5
+		for k, e := range x {
+			n += valueSize(k) + valueSize(e)
+		}
+		return n
+	case map[interface{}]interface{}:
+		var n int64 = 5
+		for k, e := range x {
+			n += valueSize(k) + valueSize(e)
+		}
+		return n
+	}
+	return 9
+}
+
+// GrowGenerator grows records over time, each write appending to a list bin
+// or putting into a map bin rather than replacing it. Once a bin exceeds the
+// cap, lists are trimmed to their newest elements, or the bin is reset,
+// depending on the growth policy. The bytes a bin takes are estimated from
+// its number of elements and the average size of the elements written.
+func GrowGenerator(client *aerospike.Client, keys KeyGenerator, data *DataModel, growth *GrowthOptions, policy *aerospike.WritePolicy, ttls func() int64) func() bool {
+
+	b := growthBin(data, growth.Bin)
+	if b == nil {
+		return func() bool { return false }
+	}
+
+	trim := strings.ToLower(growth.Policy) == "trim"
+	if trim && b.Value.List == nil {
+		logWarn("Only list bins can be trimmed, map bin %s will be reset instead", b.Name)
+		trim = false
+	}
+
+	var elements, elementBytes int64
+
+	return func() bool {
+		k := keys.GetKey()
+		if k == nil {
+			return false
+		}
+
+		var op *aerospike.Operation
+		var n int64
+		if c := b.Value.List; c != nil {
+			v := GenerateValue(&c.Value)
+			op = aerospike.ListAppendOp(b.Name, v)
+			n = valueSize(v)
+		} else {
+			c := b.Value.Map
+			mk, v := mapKey(c), GenerateValue(&c.Value)
+			op = aerospike.MapPutOp(aerospike.DefaultMapPolicy(), b.Name, mk, v)
+			n = valueSize(mk) + valueSize(v)
+		}
+		average := atomic.AddInt64(&elementBytes, n) / atomic.AddInt64(&elements, 1)
+
+		p := ttlPolicy(policy, ttls)
+
+		start := time.Now()
+		rec, err := client.Operate(p, k, op)
+		size := growthSize(rec, b.Name)
+
+		if err == nil && growth.Cap > 0 && size > growth.Cap {
+			if trim {
+				_, err = client.Operate(p, k, aerospike.ListTrimOp(b.Name, int(size-growth.Cap), int(growth.Cap)))
+			} else if b.Value.List != nil {
+				_, err = client.Operate(p, k, aerospike.ListClearOp(b.Name))
+			} else {
+				_, err = client.Operate(p, k, aerospike.MapClearOp(b.Name))
+			}
+		}
+
+		statGrow(&CURRENT_STATS.Grows, size, size*average, err)
+		statForeground(&CURRENT_STATS.Grows.Stat, time.Since(start))
+		return true
+	}
+}
+
+// statGrow records the size a record grew to, in elements and approximate
+// bytes, and counts RECORD_TOO_BIG errors on top of the usual stats.
+func statGrow(s *GrowStat, size int64, bytes int64, err error) {
+
+	statUpdate(&s.Stat, err)
+
+	if t, ok := err.(types.AerospikeError); ok && t.ResultCode() == types.RECORD_TOO_BIG {
+		atomic.AddUint64(&s.TooBig, 1)
+	}
+
+	if size > 0 {
+		atomic.AddUint64(&s.Sizes, uint64(size))
+		atomic.AddUint64(&s.Bytes, uint64(bytes))
+		atomic.AddUint64(&s.Sized, 1)
+		statMax(&s.MaxSize, uint64(size))
+		statMax(&s.MaxBytes, uint64(bytes))
+	}
+}
+
+func statMax(max *uint64, v uint64) {
+	for {
+		m := atomic.LoadUint64(max)
+		if v <= m || atomic.CompareAndSwapUint64(max, m, v) {
+			return
+		}
+	}
+}
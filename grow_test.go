@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestValueSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		size  int64
+	}{
+		{"nil", nil, 1},
+		{"integer", int64(42), 9},
+		{"float", 4.2, 9},
+		{"string", "abc", 8},
+		{"empty string", "", 5},
+		{"bytes", []byte{1, 2, 3, 4}, 9},
+		{"list", []interface{}{int64(1), "ab"}, 5 + 9 + 7},
+		{"nested list", []interface{}{[]interface{}{nil}}, 5 + 5 + 1},
+		{"map", map[string]interface{}{"a": int64(1)}, 5 + 6 + 9},
+		{"map of any", map[interface{}]interface{}{int64(1): "a"}, 5 + 9 + 6},
+		{"empty map", map[string]interface{}{}, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if size := valueSize(test.value); size != test.size {
+				t.Errorf("size = %d, want %d", size, test.size)
+			}
+		})
+	}
+}
+
+func TestStatMax(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []uint64
+		max    uint64
+	}{
+		{"none", nil, 0},
+		{"increasing", []uint64{1, 2, 3}, 3},
+		{"decreasing", []uint64{3, 2, 1}, 3},
+		{"peak", []uint64{1, 7, 2}, 7},
+		{"zero", []uint64{0}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var max uint64
+			for _, v := range test.values {
+				statMax(&max, v)
+			}
+			if max != test.max {
+				t.Errorf("max = %d, want %d", max, test.max)
+			}
+		})
+	}
+}
+
+func TestStatMaxConcurrent(t *testing.T) {
+	var max uint64
+	var wg sync.WaitGroup
+	for g := uint64(0); g < 8; g++ {
+		wg.Add(1)
+		go func(g uint64) {
+			defer wg.Done()
+			for v := uint64(0); v < 1000; v++ {
+				statMax(&max, v*8+g)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if max != 7999 {
+		t.Errorf("max = %d, want 7999", max)
+	}
+}
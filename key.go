@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"github.com/aerospike/aerospike-client-go"
 	"math/rand"
 	"sync/atomic"
@@ -10,6 +11,13 @@ type KeyGenerator interface {
 	GetKey() *aerospike.Key
 }
 
+// KeySpace is a fixed number of keys addressed by index, which the key
+// distributions choose from.
+type KeySpace interface {
+	KeyCount() int64
+	KeyAt(i int64) *aerospike.Key
+}
+
 type PooledKeyGenerator struct {
 	Size     int64
 	Capacity int64
@@ -30,7 +38,7 @@ func NewPooledKeyGenerator(model *DataModel, n int64) *PooledKeyGenerator {
 func (g *PooledKeyGenerator) generate() {
 	var i int64
 	for i = 0; i < g.Capacity; i++ {
-		if key, err := aerospike.NewKey(g.Model.Keys.Namespace, g.Model.Keys.Set, GenerateValueSeed(&g.Model.Keys.Key, i)); err == nil {
+		if key, err := aerospike.NewKey(g.Model.Keys.Namespace, g.Model.Keys.Set, GenerateKeySeed(&g.Model.Keys, i)); err == nil {
 			g.Keys[i] = key
 			atomic.AddInt64(&g.Size, 1)
 		}
@@ -38,15 +46,26 @@ func (g *PooledKeyGenerator) generate() {
 }
 
 func (g *PooledKeyGenerator) GetKey() *aerospike.Key {
-	n := g.Size
+	n := atomic.LoadInt64(&g.Size)
 	if n > 0 {
-		i := rand.Int63() % n
-		return g.Keys[i]
+		return g.Keys[rand.Int63()%n]
 	} else {
 		return nil
 	}
 }
 
+func (g *PooledKeyGenerator) KeyCount() int64 {
+	return g.Capacity
+}
+
+// KeyAt returns nil for keys not generated yet.
+func (g *PooledKeyGenerator) KeyAt(i int64) *aerospike.Key {
+	if i < 0 || i >= atomic.LoadInt64(&g.Size) {
+		return nil
+	}
+	return g.Keys[i]
+}
+
 type OnDemandKeyGenerator struct {
 	Capacity int64
 	Model    *DataModel
@@ -61,9 +80,73 @@ func NewOnDemandKeyGenerator(model *DataModel, n int64) *OnDemandKeyGenerator {
 }
 
 func (g *OnDemandKeyGenerator) GetKey() *aerospike.Key {
-	i := rand.Int63() % g.Capacity
-	if key, err := aerospike.NewKey(g.Model.Keys.Namespace, g.Model.Keys.Set, GenerateValueSeed(&g.Model.Keys.Key, i)); err == nil {
+	return g.KeyAt(rand.Int63() % g.Capacity)
+}
+
+func (g *OnDemandKeyGenerator) KeyCount() int64 {
+	return g.Capacity
+}
+
+func (g *OnDemandKeyGenerator) KeyAt(i int64) *aerospike.Key {
+	if key, err := aerospike.NewKey(g.Model.Keys.Namespace, g.Model.Keys.Set, GenerateKeySeed(&g.Model.Keys, i)); err == nil {
 		return key
 	}
 	return nil
 }
+
+// HotKeyGenerator sends a fraction of the keys to a small, fixed set of hot
+// keys drawn from another generator, and the rest to that generator.
+type HotKeyGenerator struct {
+	Keys     KeyGenerator
+	Hot      []*aerospike.Key
+	Fraction float64
+	Stat     *HotKeyStat
+}
+
+func NewHotKeyGenerator(keys KeyGenerator, n int64, fraction float64) *HotKeyGenerator {
+	g := &HotKeyGenerator{
+		Keys:     keys,
+		Hot:      []*aerospike.Key{},
+		Fraction: fraction,
+		Stat:     &HotKeyStat{},
+	}
+
+	// draw distinct keys, giving up on duplicates after a while
+	seen := map[string]bool{}
+	for i := int64(0); int64(len(g.Hot)) < n && i < n*10; i++ {
+		if key := keys.GetKey(); key != nil && !seen[string(key.Digest())] {
+			seen[string(key.Digest())] = true
+			g.Hot = append(g.Hot, key)
+			g.Stat.Keys = append(g.Stat.Keys, fmt.Sprintf("%v", key.Value()))
+		}
+	}
+
+	g.Stat.Counts = make([]uint64, len(g.Hot))
+	g.Stat.Previous = make([]uint64, len(g.Hot))
+	return g
+}
+
+// Over shares the hot keys, and their stats, with another generator for the
+// rest of the keys.
+func (g *HotKeyGenerator) Over(keys KeyGenerator) *HotKeyGenerator {
+	h := *g
+	h.Keys = keys
+	return &h
+}
+
+func (g *HotKeyGenerator) GetKey() *aerospike.Key {
+	if len(g.Hot) > 0 && rand.Float64() < g.Fraction {
+		i := rand.Intn(len(g.Hot))
+		statHotKey(g.Stat, i)
+		return g.Hot[i]
+	}
+	return g.Keys.GetKey()
+}
+
+// Acknowledge passes on the acknowledgement of a write to the generator of
+// the rest of the keys, when it takes them.
+func (g *HotKeyGenerator) Acknowledge(key *aerospike.Key, written bool) {
+	if ack, ok := g.Keys.(KeyAcknowledger); ok {
+		ack.Acknowledge(key, written)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	LIMITER_PAUSE time.Duration = 10 * time.Millisecond
+)
+
+// Limiter spaces operations evenly to a target rate. It is shared by all the
+// workers of an operation type, each reserving the next free slot before
+// running. Slots missed while workers are busy are not made up later.
+type Limiter struct {
+	tps   int64
+	next  time.Time
+	mutex sync.Mutex
+}
+
+func NewLimiter(tps int64) *Limiter {
+	return &Limiter{
+		tps:  tps,
+		next: time.Now(),
+	}
+}
+
+// SetRate changes the target rate. A rate of zero pauses the limiter.
+func (l *Limiter) SetRate(tps int64) {
+	l.mutex.Lock()
+	l.tps = tps
+	l.mutex.Unlock()
+}
+
+func (l *Limiter) Rate() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.tps
+}
+
+// Wait blocks until the caller's reserved slot is due.
+func (l *Limiter) Wait() {
+
+	l.mutex.Lock()
+	for l.tps <= 0 {
+		l.mutex.Unlock()
+		time.Sleep(LIMITER_PAUSE)
+		l.mutex.Lock()
+	}
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = l.next.Add(time.Second / time.Duration(l.tps))
+	l.mutex.Unlock()
+
+	if wait := slot.Sub(now); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Limit wraps an operation so each call waits for a slot first.
+func (l *Limiter) Limit(op func()) func() {
+	return func() {
+		l.Wait()
+		op()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		tps     int64
+		workers int
+		calls   int           // per worker
+		idle    time.Duration // before the first call
+		min     time.Duration // the calls take at least
+	}{
+		{"single", 100, 1, 1, 0, 0},
+		{"spaced", 200, 1, 11, 0, 50 * time.Millisecond},
+		{"shared", 400, 4, 6, 0, 57 * time.Millisecond},
+		{"idle not made up", 100, 1, 6, 100 * time.Millisecond, 50 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := NewLimiter(test.tps)
+			time.Sleep(test.idle)
+
+			start := time.Now()
+			var wg sync.WaitGroup
+			for w := 0; w < test.workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < test.calls; i++ {
+						l.Wait()
+					}
+				}()
+			}
+			wg.Wait()
+
+			elapsed := time.Since(start)
+			if elapsed < test.min {
+				t.Errorf("took %v, want at least %v", elapsed, test.min)
+			}
+			if elapsed > 4*test.min+50*time.Millisecond {
+				t.Errorf("took %v, want about %v", elapsed, test.min)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 
@@ -14,6 +15,13 @@ import (
 	daemon "github.com/sevlyar/go-daemon"
 )
 
+// exit statuses of a bounded run, distinct from log.Fatal (1) and panics (2).
+// Only `run` exits with them, `start` returns as soon as the daemon forks.
+const (
+	EXIT_OK       = 0
+	EXIT_FAILURES = 3
+)
+
 var (
 	spec                      = map[string]interface{}{}
 	rootPath    string        = currentDir()
@@ -26,6 +34,8 @@ var (
 	signame     string        = ""
 
 	executor *Executor = nil
+	stopped  bool      = false
+	mutex    sync.Mutex
 )
 
 func main() {
@@ -85,6 +95,8 @@ func main() {
 		cmdStatus(context)
 	case "start":
 		cmdStart(context)
+	case "run":
+		cmdRun()
 	}
 }
 
@@ -150,9 +162,17 @@ func checkDir(dir string) string {
 	return dir
 }
 
+// sigTerm stops the running executor, which reports and saves its progress
+// before the process exits, and keeps the phases left from starting.
 func sigTerm(sig os.Signal) error {
-	os.Exit(0)
-	return nil
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	stopped = true
+	if executor != nil {
+		executor.Stop()
+	}
+	return daemon.ErrStop
 }
 
 func sigHup(sig os.Signal) error {
@@ -211,7 +231,23 @@ func cmdStart(context *daemon.Context) {
 	if d != nil {
 		return
 	}
-	defer context.Release()
+
+	status := execute()
+	context.Release()
+	os.Exit(status)
+}
+
+// cmdRun runs in the foreground instead of as a daemon, logging to stdout,
+// so scripts can wait for a bounded run and check its exit status.
+func cmdRun() {
+	daemon.SetSigHandler(sigTerm, syscall.SIGINT)
+	os.Exit(execute())
+}
+
+// execute runs each phase of the config in turn, and returns the exit status.
+func execute() int {
+
+	var err error = nil
 
 	// utlize full cores
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -248,30 +284,60 @@ func cmdStart(context *daemon.Context) {
 		panicOnError(err)
 	}
 
-	var loadModel *LoadModel = &config.LoadModel
 	var dataModel *DataModel = &config.DataModel
 
-	// generate keys
-	// keys := NewPooledKeyGenerator(dataModel, loadModel.Keys)
-	// keys.generate()
-	keys := NewOnDemandKeyGenerator(dataModel, loadModel.Keys)
-
 	// generate record permutations
 	recs := NewPooledRecordGenerator(dataModel, 100)
 	recs.generate()
 
-	// new executor
-	exec := NewExecutor(client, loadModel, keys, recs)
+	// serve signals
+	go func() {
+		err := daemon.ServeSignals()
+		panicOnError(err)
+	}()
+
+	// run each phase in turn, until stopped by a signal or the limits of its
+	// load model, resetting the stats in between
+	var failures uint64 = 0
+	for _, phase := range config.Plan() {
+
+		var loadModel *LoadModel = &phase.LoadModel
+
+		// generate keys
+		// keys := NewPooledKeyGenerator(dataModel, loadModel.Keys)
+		// keys.generate()
+		keys := NewOnDemandKeyGenerator(dataModel, loadModel.Keys)
+
+		// new executor, unless stopped in the meantime
+		exec := NewExecutor(client, loadModel, dataModel, &config.Policies, keys, recs)
+		mutex.Lock()
+		if stopped {
+			mutex.Unlock()
+			break
+		}
+		executor = exec
+		mutex.Unlock()
+
+		// run
+		statsPhase(phase.Name)
+		if phase.Name != "" {
+			logInfo("Running Executor for phase %s", phase.Name)
+		} else {
+			logInfo("Running Executor")
+		}
+		start := time.Now()
+		exec.Run()
 
-	// run
-	logInfo("Running Executor")
-	go exec.Run()
+		// report
+		statsFlush()
+		failures += statsSummary(time.Since(start))
+		statsReset()
+	}
 
-	// serve signals
-	err = daemon.ServeSignals()
-	panicOnError(err)
+	client.Close()
 
-	// exit handled by signal handlers
-	halt := make(chan bool)
-	<-halt
+	if failures > 0 {
+		return EXIT_FAILURES
+	}
+	return EXIT_OK
 }
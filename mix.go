@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// Mix picks among a fixed set of choices in proportion to their weights.
+type Mix struct {
+	Total      int64
+	Cumulative []int64
+}
+
+func NewMix(weights ...int64) *Mix {
+	m := &Mix{
+		Total:      0,
+		Cumulative: make([]int64, len(weights)),
+	}
+	for i, w := range weights {
+		if w > 0 {
+			m.Total += w
+		}
+		m.Cumulative[i] = m.Total
+	}
+	return m
+}
+
+// Pick returns the index of the chosen weight, or -1 if all weights are zero.
+func (m *Mix) Pick() int {
+	if m.Total <= 0 {
+		return -1
+	}
+	r := rand.Int63n(m.Total)
+	for i, c := range m.Cumulative {
+		if r < c {
+			return i
+		}
+	}
+	return -1
+}
+
+// LiveMix is a mix whose choices can be dropped while workers pick from it.
+type LiveMix struct {
+	weights []int64
+	mix     atomic.Value
+	mutex   sync.Mutex
+}
+
+func NewLiveMix(weights ...int64) *LiveMix {
+	m := &LiveMix{
+		weights: append([]int64{}, weights...),
+	}
+	m.mix.Store(NewMix(m.weights...))
+	return m
+}
+
+// Pick returns the index of the chosen weight, dropping the choices found
+// `stopped` and picking again, or -1 once every choice is dropped.
+func (m *LiveMix) Pick(stopped func(i int) bool) int {
+	for {
+		i := m.mix.Load().(*Mix).Pick()
+		if i < 0 || !stopped(i) {
+			return i
+		}
+		m.Drop(i)
+	}
+}
+
+// Drop zeroes the weight of a choice, so it is not picked again.
+func (m *LiveMix) Drop(i int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.weights[i] > 0 {
+		m.weights[i] = 0
+		m.mix.Store(NewMix(m.weights...))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMixPick(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int64
+		shares  []float64 // expected share of the picks of each choice
+	}{
+		{"none", nil, nil},
+		{"zero", []int64{0, 0}, nil},
+		{"single", []int64{5}, []float64{1}},
+		{"even", []int64{1, 1}, []float64{0.5, 0.5}},
+		{"weighted", []int64{1, 3}, []float64{0.25, 0.75}},
+		{"zero weight", []int64{2, 0, 2}, []float64{0.5, 0, 0.5}},
+		{"negative weight", []int64{-4, 1}, []float64{0, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMix(test.weights...)
+			if test.shares == nil {
+				if i := m.Pick(); i != -1 {
+					t.Fatalf("picked %d, want -1", i)
+				}
+				return
+			}
+
+			const picks = 20000
+			counts := make([]int, len(test.weights))
+			for n := 0; n < picks; n++ {
+				i := m.Pick()
+				if i < 0 || i >= len(test.weights) {
+					t.Fatalf("picked %d, out of %d choices", i, len(test.weights))
+				}
+				counts[i]++
+			}
+			for i, share := range test.shares {
+				if got := float64(counts[i]) / picks; math.Abs(got-share) > 0.02 {
+					t.Errorf("choice %d picked %.3f of the time, want %.3f", i, got, share)
+				}
+			}
+		})
+	}
+}
+
+func TestLiveMixPick(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int64
+		stopped []bool
+		live    []bool // choices that may still be picked, nil when none
+	}{
+		{"none stopped", []int64{1, 1, 1}, []bool{false, false, false}, []bool{true, true, true}},
+		{"one stopped", []int64{1, 1, 1}, []bool{false, true, false}, []bool{true, false, true}},
+		{"heaviest stopped", []int64{1, 100}, []bool{false, true}, []bool{true, false}},
+		{"zero weight left", []int64{1, 0}, []bool{true, false}, nil},
+		{"all stopped", []int64{1, 2, 3}, []bool{true, true, true}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewLiveMix(test.weights...)
+			stopped := func(i int) bool {
+				return test.stopped[i]
+			}
+
+			for n := 0; n < 1000; n++ {
+				i := m.Pick(stopped)
+				if test.live == nil {
+					if i != -1 {
+						t.Fatalf("picked %d, want -1", i)
+					}
+					continue
+				}
+				if i < 0 || !test.live[i] {
+					t.Fatalf("picked %d, want a live choice", i)
+				}
+			}
+
+			// dropped choices stay dropped
+			for n := 0; n < 1000; n++ {
+				if i := m.Pick(func(int) bool { return false }); i >= 0 && test.stopped[i] {
+					t.Fatalf("picked %d again once dropped", i)
+				}
+			}
+		})
+	}
+}
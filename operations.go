@@ -1,34 +1,448 @@
 package main
 
 import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
 	"github.com/aerospike/aerospike-client-go"
+	"github.com/aerospike/aerospike-client-go/types"
+)
+
+var (
+	CAS_RETRIES int64 = 5
+)
+
+const (
+	READ_FULL = iota
+	READ_EXISTS
+	READ_HEADER
+	READ_BINS
 )
 
-func ReadGenerator(client *aerospike.Client, keys KeyGenerator) func() {
+// checkBins returns an error unless the bins are all in the data model, and
+// there is at least one.
+func checkBins(data *DataModel, bins []string) error {
+	if len(bins) == 0 {
+		return fmt.Errorf("no bin to read")
+	}
+
+	names := map[string]bool{}
+	for _, b := range data.Bins {
+		names[b.Name] = true
+	}
+	for _, name := range bins {
+		if !names[name] {
+			return fmt.Errorf("bin %s is not in the data model", name)
+		}
+	}
+	return nil
+}
+
+// ReadGenerator reads records in one of several ways, weighted by the mix:
+// the full record, only whether it exists, only its header, or only the
+// configured bins. Full records are read when the mix is empty.
+func ReadGenerator(client *aerospike.Client, keys KeyGenerator, policy *aerospike.BasePolicy, mix *ReadMix, bins []string) func() bool {
 
-	var err error
-	policy := aerospike.NewPolicy()
+	variants := NewMix(mix.Full, mix.Exists, mix.Header, mix.Bins)
 
-	return func() {
+	return func() bool {
 		if k := keys.GetKey(); k != nil {
-			_, err = client.Get(policy, k)
-			statUpdate(&CURRENT_STATS.Reads, err)
+			var err error
+			var stat *Stat
+
+			start := time.Now()
+			switch variants.Pick() {
+			case READ_EXISTS:
+				_, err = client.Exists(policy, k)
+				stat = &CURRENT_STATS.ReadExists
+			case READ_HEADER:
+				_, err = client.GetHeader(policy, k)
+				stat = &CURRENT_STATS.ReadHeaders
+			case READ_BINS:
+				_, err = client.Get(policy, k, bins...)
+				stat = &CURRENT_STATS.ReadBins
+			default:
+				_, err = client.Get(policy, k)
+				stat = &CURRENT_STATS.Reads
+			}
+			statUpdate(stat, err)
+			statForeground(stat, time.Since(start))
+			return true
+		}
+		return false
+	}
+}
+
+func WriteGenerator(client *aerospike.Client, keys KeyGenerator, records RecordGenerator, policy *aerospike.WritePolicy, ttls func() int64, verifier *TTLVerifier) func() bool {
+
+	ack, _ := keys.(KeyAcknowledger)
+
+	return func() bool {
+		k := keys.GetKey()
+		if k == nil {
+			return false
+		}
+
+		written := false
+		b := records.GetRecord()
+		if b != nil {
+			start := time.Now()
+			err := ttlWrite(client, policy, k, b, ttls, verifier)
+			statUpdate(&CURRENT_STATS.Writes, err)
+			statForeground(&CURRENT_STATS.Writes, time.Since(start))
+			written = err == nil
+		}
+		if ack != nil {
+			ack.Acknowledge(k, written)
+		}
+		return b != nil
+	}
+}
+
+// CASWriteGenerator writes records with an optimistic concurrency check: it
+// reads the record, then writes it back expecting the generation it read,
+// retrying up to `retries` times when another writer got there first. Records
+// not found are created, expecting that no one else creates them. Only the
+// retries of writes that succeed are counted.
+func CASWriteGenerator(client *aerospike.Client, keys KeyGenerator, records RecordGenerator, readPolicy *aerospike.BasePolicy, writePolicy *aerospike.WritePolicy, ttls func() int64, retries int64) func() bool {
+
+	return func() bool {
+		k := keys.GetKey()
+		b := records.GetRecord()
+		if k == nil || b == nil {
+			return false
+		}
+
+		var err error
+		var attempt int64
+
+		start := time.Now()
+		for attempt = 0; ; attempt++ {
+			policy := *ttlPolicy(writePolicy, ttls)
+
+			var rec *aerospike.Record
+			rec, err = client.Get(readPolicy, k)
+			if err == nil {
+				policy.GenerationPolicy = aerospike.EXPECT_GEN_EQUAL
+				policy.Generation = int32(rec.Generation)
+			} else if t, ok := err.(types.AerospikeError); ok && t.ResultCode() == types.KEY_NOT_FOUND_ERROR {
+				policy.GenerationPolicy = aerospike.NONE
+				policy.RecordExistsAction = aerospike.CREATE_ONLY
+			} else {
+				break
+			}
+
+			err = client.PutBins(&policy, k, b...)
+			if !statCASConflict(&CURRENT_STATS.CASWrites, err) || attempt >= retries {
+				break
+			}
+		}
+
+		if err == nil {
+			statCASRetries(&CURRENT_STATS.CASWrites, uint64(attempt))
+		}
+		statUpdate(&CURRENT_STATS.CASWrites.Stat, err)
+		statForeground(&CURRENT_STATS.CASWrites.Stat, time.Since(start))
+		return true
+	}
+}
+
+func BatchReadGenerator(client *aerospike.Client, keys KeyGenerator, size *IntegerConstraints, policy *aerospike.BasePolicy) func() bool {
+
+	return func() bool {
+		n := GenerateInteger(size)
+		if n < 1 {
+			n = 1
+		}
+
+		batch := make([]*aerospike.Key, 0, n)
+		for i := int64(0); i < n; i++ {
+			if k := keys.GetKey(); k != nil {
+				batch = append(batch, k)
+			}
+		}
+
+		if len(batch) == 0 {
+			return false
+		}
+
+		start := time.Now()
+		_, err := client.BatchGet(policy, batch)
+		statBatch(&CURRENT_STATS.BatchReads, uint64(len(batch)))
+		statUpdate(&CURRENT_STATS.BatchReads.Stat, err)
+		statForeground(&CURRENT_STATS.BatchReads.Stat, time.Since(start))
+		return true
+	}
+}
+
+const (
+	OPERATE_ADD = iota
+	OPERATE_APPEND
+	OPERATE_PREPEND
+	OPERATE_TOUCH
+	OPERATE_GET
+)
+
+// newOperateOp builds a read-modify-write operation of the given kind: a
+// modify, drawing its bin and operand from the data model constraints, paired
+// with a read of the record. Add applies to integer bins, append and prepend
+// to string bins.
+func newOperateOp(kind int, integerBins []*BinConstraints, stringBins []*BinConstraints) []*aerospike.Operation {
+	var op *aerospike.Operation
+	switch kind {
+	case OPERATE_ADD:
+		if len(integerBins) > 0 {
+			b := integerBins[rand.Intn(len(integerBins))]
+			op = aerospike.AddOp(aerospike.NewBin(b.Name, GenerateInteger(b.Value.Integer)))
+		}
+	case OPERATE_APPEND:
+		if len(stringBins) > 0 {
+			b := stringBins[rand.Intn(len(stringBins))]
+			op = aerospike.AppendOp(aerospike.NewBin(b.Name, GenerateString(b.Value.String)))
+		}
+	case OPERATE_PREPEND:
+		if len(stringBins) > 0 {
+			b := stringBins[rand.Intn(len(stringBins))]
+			op = aerospike.PrependOp(aerospike.NewBin(b.Name, GenerateString(b.Value.String)))
 		}
+	case OPERATE_TOUCH:
+		op = aerospike.TouchOp()
+	case OPERATE_GET:
+		return []*aerospike.Operation{aerospike.GetOp()}
 	}
+
+	if op == nil {
+		return nil
+	}
+	return []*aerospike.Operation{op, aerospike.GetOp()}
+}
+
+// operateBins returns the integer and string bins of the data model.
+func operateBins(data *DataModel) ([]*BinConstraints, []*BinConstraints) {
+	integerBins := []*BinConstraints{}
+	stringBins := []*BinConstraints{}
+	for _, b := range data.Bins {
+		if b.Value.Integer != nil {
+			integerBins = append(integerBins, b)
+		} else if b.Value.String != nil {
+			stringBins = append(stringBins, b)
+		}
+	}
+	return integerBins, stringBins
 }
 
-func WriteGenerator(client *aerospike.Client, keys KeyGenerator, records RecordGenerator, ttl int64) func() {
+// operateMix returns the operate mix, uniform when no weight is set, without
+// the operations the data model has no bins for.
+func operateMix(data *DataModel, mix *OperateMix) *Mix {
 
-	var err error
-	policy := aerospike.NewWritePolicy(0, int32(ttl))
-	policy.SendKey = true
+	weights := []int64{mix.Add, mix.Append, mix.Prepend, mix.Touch, mix.Get}
+	if NewMix(weights...).Total <= 0 {
+		weights = []int64{1, 1, 1, 1, 1}
+	}
 
-	return func() {
+	integerBins, stringBins := operateBins(data)
+	if len(integerBins) == 0 {
+		weights[OPERATE_ADD] = 0
+	}
+	if len(stringBins) == 0 {
+		weights[OPERATE_APPEND] = 0
+		weights[OPERATE_PREPEND] = 0
+	}
+	return NewMix(weights...)
+}
+
+func OperateGenerator(client *aerospike.Client, keys KeyGenerator, data *DataModel, mix *OperateMix, policy *aerospike.WritePolicy, ttls func() int64) func() bool {
+
+	ops := operateMix(data, mix)
+	integerBins, stringBins := operateBins(data)
+
+	return func() bool {
+		op := newOperateOp(ops.Pick(), integerBins, stringBins)
+		if op == nil {
+			return false
+		}
+
+		if k := keys.GetKey(); k != nil {
+			start := time.Now()
+			_, err := client.Operate(ttlPolicy(policy, ttls), k, op...)
+			statUpdate(&CURRENT_STATS.Operates, err)
+			statForeground(&CURRENT_STATS.Operates, time.Since(start))
+			return true
+		}
+		return false
+	}
+}
+
+// udfPackage returns the package name of a UDF module file, which is the file
+// name without its extension.
+func udfPackage(module string) string {
+	name := filepath.Base(module)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func UDFGenerator(client *aerospike.Client, keys KeyGenerator, udf *UDFOptions, policy *aerospike.WritePolicy, ttls func() int64) func() bool {
+
+	pkg := udfPackage(udf.Module)
+
+	return func() bool {
 		if k := keys.GetKey(); k != nil {
-			if b := records.GetRecord(); b != nil {
-				err = client.PutBins(policy, k, b...)
-				statUpdate(&CURRENT_STATS.Writes, err)
+			args := make([]aerospike.Value, len(udf.Args))
+			for i := range udf.Args {
+				args[i] = aerospike.NewValue(GenerateValue(&udf.Args[i]))
 			}
+
+			start := time.Now()
+			_, err := client.Execute(ttlPolicy(policy, ttls), k, pkg, udf.Function, args...)
+			statUDF(&CURRENT_STATS.UDFs, err)
+			statForeground(&CURRENT_STATS.UDFs.Stat, time.Since(start))
+			return true
+		}
+		return false
+	}
+}
+
+func DeleteGenerator(client *aerospike.Client, keys KeyGenerator, policy *aerospike.WritePolicy) func() bool {
+
+	return func() bool {
+		if k := keys.GetKey(); k != nil {
+			start := time.Now()
+			_, err := client.Delete(policy, k)
+			statUpdate(&CURRENT_STATS.Deletes, err)
+			statForeground(&CURRENT_STATS.Deletes, time.Since(start))
+			return true
+		}
+		return false
+	}
+}
+
+// queryFilter builds a predicate for an indexed bin from its constraints.
+// Integer bins alternate between equality and range predicates, where the
+// range is at most `width` wide. String bins only support equality.
+func queryFilter(c *BinConstraints, width int64) *aerospike.Filter {
+	if c.Value.Integer != nil {
+		begin := GenerateInteger(c.Value.Integer)
+		if width > 0 && rand.Intn(2) == 0 {
+			end := begin + randomInRange(0, width)
+			if end > c.Value.Integer.Max {
+				end = c.Value.Integer.Max
+			}
+			return aerospike.NewRangeFilter(c.Name, begin, end)
 		}
+		return aerospike.NewEqualFilter(c.Name, begin)
+	} else if c.Value.String != nil {
+		return aerospike.NewEqualFilter(c.Name, GenerateString(c.Value.String))
+	}
+	return nil
+}
+
+// drainRecordset consumes a recordset until it is exhausted, returning the
+// number of records received and the last error reported. When `rps` is
+// positive, records are consumed no faster than `rps` records per second.
+func drainRecordset(rs *aerospike.Recordset, rps int64) (uint64, error) {
+
+	var n uint64 = 0
+	var err error = nil
+
+	defer rs.Close()
+
+	start := time.Now()
+
+	for {
+		select {
+		case _, open := <-rs.Records:
+			if !open {
+				return n, err
+			}
+			n++
+			if rps > 0 {
+				due := time.Duration(n) * time.Second / time.Duration(rps)
+				if wait := due - time.Since(start); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+		case e := <-rs.Errors:
+			if e != nil {
+				err = e
+			}
+		}
+	}
+}
+
+// queryBins returns the bins queries can filter on: indexed integer and
+// string bins.
+func queryBins(data *DataModel) []*BinConstraints {
+	bins := []*BinConstraints{}
+	for _, b := range data.Bins {
+		if b.Indexed && (b.Value.Integer != nil || b.Value.String != nil) {
+			bins = append(bins, b)
+		}
+	}
+	return bins
+}
+
+func QueryGenerator(client *aerospike.Client, data *DataModel, width int64, policy *aerospike.QueryPolicy) func() bool {
+
+	bins := queryBins(data)
+
+	return func() bool {
+		if len(bins) == 0 {
+			return false
+		}
+
+		b := bins[rand.Intn(len(bins))]
+		stmt := aerospike.NewStatement(data.Keys.Namespace, data.Keys.Set)
+		stmt.Addfilter(queryFilter(b, width))
+
+		var n uint64 = 0
+		start := time.Now()
+		rs, err := client.Query(policy, stmt)
+		if err == nil {
+			n, err = drainRecordset(rs, 0)
+		}
+		statQuery(&CURRENT_STATS.Queries, n)
+		statUpdate(&CURRENT_STATS.Queries.Stat, err)
+		statLatency(&CURRENT_STATS.Queries.Stat, time.Since(start))
+		return true
+	}
+}
+
+func scanPriority(p string) aerospike.Priority {
+	switch strings.ToLower(p) {
+	case "low":
+		return aerospike.LOW
+	case "medium":
+		return aerospike.MEDIUM
+	case "high":
+		return aerospike.HIGH
+	}
+	return aerospike.DEFAULT
+}
+
+func ScanGenerator(client *aerospike.Client, data *DataModel, options *ScanOptions) func() bool {
+
+	policy := aerospike.NewScanPolicy()
+	policy.Priority = scanPriority(options.Priority)
+	policy.ConcurrentNodes = options.Concurrency != 1
+	policy.MaxConcurrentNodes = options.Concurrency
+
+	return func() bool {
+		var n uint64 = 0
+
+		atomic.AddInt64(&CURRENT_STATS.Scans.Active, 1)
+		start := time.Now()
+		rs, err := client.ScanAll(policy, data.Keys.Namespace, data.Keys.Set, options.Bins...)
+		if err == nil {
+			n, err = drainRecordset(rs, options.RecordsPerSecond)
+		}
+		atomic.AddInt64(&CURRENT_STATS.Scans.Active, -1)
+
+		statScan(&CURRENT_STATS.Scans, n)
+		statUpdate(&CURRENT_STATS.Scans.Stat, err)
+		statLatency(&CURRENT_STATS.Scans.Stat, time.Since(start))
+		return true
 	}
 }
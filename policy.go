@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aerospike/aerospike-client-go"
+)
+
+// base applies the settings shared by every kind of policy. Settings left
+// unset keep the client's defaults.
+func (m *PolicyModel) base(p *aerospike.BasePolicy) {
+
+	if m.Timeout > 0 {
+		p.Timeout = m.Timeout
+	}
+	if m.MaxRetries != nil {
+		p.MaxRetries = *m.MaxRetries
+	}
+	if m.SleepBetweenRetries > 0 {
+		p.SleepBetweenRetries = m.SleepBetweenRetries
+	}
+	if m.SendKey != nil {
+		p.SendKey = *m.SendKey
+	}
+
+	switch strings.ToLower(m.Replica) {
+	case "":
+	case "master":
+		p.ReplicaPolicy = aerospike.MASTER
+	case "master_proles":
+		p.ReplicaPolicy = aerospike.MASTER_PROLES
+	case "random":
+		p.ReplicaPolicy = aerospike.RANDOM
+	default:
+		logWarn("Ignoring unknown replica policy %s", m.Replica)
+	}
+
+	switch strings.ToLower(m.Consistency) {
+	case "":
+	case "one":
+		p.ConsistencyLevel = aerospike.CONSISTENCY_ONE
+	case "all":
+		p.ConsistencyLevel = aerospike.CONSISTENCY_ALL
+	default:
+		logWarn("Ignoring unknown consistency level %s", m.Consistency)
+	}
+}
+
+func (m *PolicyModel) ReadPolicy() *aerospike.BasePolicy {
+	p := aerospike.NewPolicy()
+	m.base(p)
+	return p
+}
+
+// WritePolicy returns a write policy with the given ttl. Keys are sent to
+// the server unless configured otherwise.
+func (m *PolicyModel) WritePolicy(ttl int64) *aerospike.WritePolicy {
+
+	p := aerospike.NewWritePolicy(0, int32(ttl))
+	p.SendKey = true
+	m.base(&p.BasePolicy)
+
+	switch strings.ToLower(m.Commit) {
+	case "":
+	case "all":
+		p.CommitLevel = aerospike.COMMIT_ALL
+	case "master":
+		p.CommitLevel = aerospike.COMMIT_MASTER
+	default:
+		logWarn("Ignoring unknown commit level %s", m.Commit)
+	}
+
+	switch strings.ToLower(m.RecordExists) {
+	case "":
+	case "update":
+		p.RecordExistsAction = aerospike.UPDATE
+	case "update_only":
+		p.RecordExistsAction = aerospike.UPDATE_ONLY
+	case "replace":
+		p.RecordExistsAction = aerospike.REPLACE
+	case "replace_only":
+		p.RecordExistsAction = aerospike.REPLACE_ONLY
+	case "create_only":
+		p.RecordExistsAction = aerospike.CREATE_ONLY
+	default:
+		logWarn("Ignoring unknown record exists action %s", m.RecordExists)
+	}
+
+	switch strings.ToLower(m.Generation) {
+	case "":
+	case "none":
+		p.GenerationPolicy = aerospike.NONE
+	case "expect_gen_equal":
+		p.GenerationPolicy = aerospike.EXPECT_GEN_EQUAL
+	case "expect_gen_gt":
+		p.GenerationPolicy = aerospike.EXPECT_GEN_GT
+	default:
+		logWarn("Ignoring unknown generation policy %s", m.Generation)
+	}
+
+	return p
+}
+
+func (m *PolicyModel) QueryPolicy() *aerospike.QueryPolicy {
+	p := aerospike.NewQueryPolicy()
+	m.base(&p.BasePolicy)
+	return p
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aerospike/aerospike-client-go"
+)
+
+var (
+	POPULATE_RETRIES int = 3
+)
+
+// Populator writes every key index from 0 to the number of keys exactly once,
+// with records generated from the key index so they are reproducible. Failed
+// writes are retried, up to POPULATE_RETRIES times, then left pending. Its
+// progress, up to the first key not written, is saved to a checkpoint file,
+// from which an interrupted run resumes.
+type Populator struct {
+	Client     *aerospike.Client
+	Data       *DataModel
+	Total      int64
+	Checkpoint string
+	Policy     *aerospike.WritePolicy
+	TTLs       func() int64
+	start      int64
+	next       int64
+	written    int64
+	inflight   map[int64]bool
+	retry      []int64
+	attempts   map[int64]int
+	failed     map[int64]bool
+	mutex      sync.Mutex
+}
+
+func NewPopulator(client *aerospike.Client, data *DataModel, total int64, checkpoint string, policy *aerospike.WritePolicy, ttls func() int64) *Populator {
+	p := &Populator{
+		Client:     client,
+		Data:       data,
+		Total:      total,
+		Checkpoint: checkpoint,
+		Policy:     policy,
+		TTLs:       ttls,
+		inflight:   map[int64]bool{},
+		attempts:   map[int64]int{},
+		failed:     map[int64]bool{},
+	}
+	p.start = p.load()
+	p.next = p.start
+	if p.start > 0 {
+		logInfo("Resuming populate from key %d of %d", p.start, p.Total)
+	}
+	return p
+}
+
+// Remaining returns the number of keys left to write.
+func (p *Populator) Remaining() int64 {
+	return p.Total - p.start
+}
+
+func (p *Populator) load() int64 {
+
+	if p.Checkpoint == "" {
+		return 0
+	}
+
+	raw, err := ioutil.ReadFile(p.Checkpoint)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logWarn("Not able to read checkpoint %s: %s", p.Checkpoint, err.Error())
+		}
+		return 0
+	}
+
+	i, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil || i < 0 || i > p.Total {
+		logWarn("Ignoring invalid checkpoint %s", p.Checkpoint)
+		return 0
+	}
+	return i
+}
+
+// watermark returns the index below which every key has been written.
+func (p *Populator) watermark() int64 {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	w := p.next
+	for i := range p.inflight {
+		if i < w {
+			w = i
+		}
+	}
+	for _, i := range p.retry {
+		if i < w {
+			w = i
+		}
+	}
+	for i := range p.failed {
+		if i < w {
+			w = i
+		}
+	}
+	if w > p.Total {
+		w = p.Total
+	}
+	return w
+}
+
+// Finished tells whether every key has been written, or given up on.
+func (p *Populator) Finished() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.next >= p.Total && len(p.retry) == 0 && len(p.inflight) == 0
+}
+
+// save writes the checkpoint, or removes it once every key is written.
+func (p *Populator) save() {
+
+	if p.Checkpoint == "" {
+		return
+	}
+
+	w := p.watermark()
+	if w >= p.Total {
+		os.Remove(p.Checkpoint)
+		return
+	}
+
+	err := ioutil.WriteFile(p.Checkpoint, []byte(strconv.FormatInt(w, 10)+"\n"), 0644)
+	if err != nil {
+		logWarn("Not able to write checkpoint %s: %s", p.Checkpoint, err.Error())
+	}
+}
+
+// claim takes the next key to write, retrying failed ones first.
+func (p *Populator) claim() (int64, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var i int64
+	if n := len(p.retry); n > 0 {
+		i = p.retry[n-1]
+		p.retry = p.retry[:n-1]
+	} else if p.next < p.Total {
+		i = p.next
+		p.next++
+	} else {
+		return 0, false
+	}
+	p.inflight[i] = true
+	return i, true
+}
+
+// release returns a claimed key, to retry it when its write failed.
+func (p *Populator) release(i int64, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.inflight, i)
+	if err == nil {
+		delete(p.attempts, i)
+		p.written++
+		return
+	}
+
+	p.attempts[i]++
+	if p.attempts[i] < POPULATE_RETRIES {
+		p.retry = append(p.retry, i)
+		return
+	}
+
+	delete(p.attempts, i)
+	p.failed[i] = true
+	logWarn("Not able to populate key %d: %s", i, err.Error())
+}
+
+func (p *Populator) record(i int64) []*aerospike.Bin {
+	bins := make([]*aerospike.Bin, len(p.Data.Bins))
+	for j, c := range p.Data.Bins {
+		bins[j] = aerospike.NewBin(c.Name, GenerateValueSeed(&c.Value, i))
+	}
+	return bins
+}
+
+func (p *Populator) Op() func() bool {
+
+	return func() bool {
+		i, ok := p.claim()
+		if !ok {
+			// only keys in flight are left, which may still fail
+			return false
+		}
+
+		k, err := aerospike.NewKey(p.Data.Keys.Namespace, p.Data.Keys.Set, GenerateKeySeed(&p.Data.Keys, i))
+		if err == nil {
+			start := time.Now()
+			err = p.Client.PutBins(ttlPolicy(p.Policy, p.TTLs), k, p.record(i)...)
+			statForeground(&CURRENT_STATS.Populates, time.Since(start))
+		}
+		statUpdate(&CURRENT_STATS.Populates, err)
+		p.release(i, err)
+		return true
+	}
+}
+
+// Save writes the checkpoint, and reports keys that could not be written.
+func (p *Populator) Save() {
+	p.save()
+
+	p.mutex.Lock()
+	failed := len(p.failed)
+	p.mutex.Unlock()
+
+	if failed > 0 {
+		logWarn("Not able to populate %d keys, populate resumes from key %d", failed, p.watermark())
+	}
+}
+
+// Report logs the progress and saves the checkpoint every interval, until
+// `done` is closed. The final checkpoint is left to Save.
+func (p *Populator) Report(interval time.Duration, done chan bool) {
+
+	begin := time.Now()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(interval):
+			p.save()
+
+			p.mutex.Lock()
+			written := p.written
+			p.mutex.Unlock()
+			total := p.start + written
+			elapsed := time.Since(begin)
+
+			var eta time.Duration = 0
+			if written > 0 {
+				eta = time.Duration(float64(elapsed) / float64(written) * float64(p.Total-total))
+			}
+
+			logInfo("Populated %.1f%% (%d/%d), eta %v", 100*float64(total)/float64(p.Total), total, p.Total, eta)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPopulatorLoad(t *testing.T) {
+	tests := []struct {
+		name       string
+		checkpoint *string
+		start      int64
+	}{
+		{"missing", nil, 0},
+		{"saved", str("42\n"), 42},
+		{"end", str("100"), 100},
+		{"past the end", str("101"), 0},
+		{"negative", str("-1"), 0},
+		{"invalid", str("forty-two"), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "checkpoint")
+			if test.checkpoint != nil {
+				if err := ioutil.WriteFile(path, []byte(*test.checkpoint), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p := NewPopulator(nil, &DataModel{}, 100, path, nil, nil)
+			if p.start != test.start {
+				t.Errorf("start = %d, want %d", p.start, test.start)
+			}
+			if p.Remaining() != 100-test.start {
+				t.Errorf("remaining = %d, want %d", p.Remaining(), 100-test.start)
+			}
+		})
+	}
+}
+
+func TestPopulatorResume(t *testing.T) {
+	fail := errors.New("timeout")
+
+	tests := []struct {
+		name   string
+		writes []error // outcome of each write, in claim order
+		saved  string  // checkpoint after the writes, "" once removed
+		resume int64
+	}{
+		{"none", nil, "0", 0},
+		{"some", []error{nil, nil, nil}, "3", 3},
+		{"all", []error{nil, nil, nil, nil, nil}, "", 0},
+		{"retried", []error{nil, fail, nil, nil}, "3", 3},
+		{"pending retry", []error{nil, nil, fail}, "2", 2},
+		{"failed", []error{nil, fail, fail, fail, nil, nil}, "1", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "checkpoint")
+
+			p := NewPopulator(nil, &DataModel{}, 5, path, nil, nil)
+			for _, err := range test.writes {
+				i, ok := p.claim()
+				if !ok {
+					t.Fatalf("no key left to claim")
+				}
+				p.release(i, err)
+			}
+			p.Save()
+
+			raw, err := ioutil.ReadFile(path)
+			switch {
+			case test.saved == "" && !os.IsNotExist(err):
+				t.Errorf("checkpoint %q left, want it removed", raw)
+			case test.saved != "" && strings.TrimSpace(string(raw)) != test.saved:
+				t.Errorf("checkpoint = %q, want %q", raw, test.saved)
+			}
+
+			resumed := NewPopulator(nil, &DataModel{}, 5, path, nil, nil)
+			if resumed.start != test.resume {
+				t.Errorf("resumed from %d, want %d", resumed.start, test.resume)
+			}
+		})
+	}
+}
+
+func str(s string) *string {
+	return &s
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	PROFILE_INTERVAL time.Duration = 100 * time.Millisecond
+)
+
+// Scale returns how much of `full` (workers or tps) to apply after `elapsed`
+// in a run lasting `duration`. Stepped profiles add `Step` per step interval,
+// otherwise the load ramps up linearly. Either is followed by a linear ramp
+// down over the end of bounded runs.
+func (p *ProfileOptions) Scale(elapsed time.Duration, duration time.Duration, full int64) int64 {
+
+	n := full
+
+	if p.Step > 0 && p.StepInterval > 0 {
+		if s := p.Step * (int64(elapsed/p.StepInterval) + 1); s < n {
+			n = s
+		}
+	} else if p.RampUp > 0 && elapsed < p.RampUp {
+		n = scaleCeil(n, int64(elapsed), int64(p.RampUp))
+	}
+
+	if p.RampDown > 0 && duration > 0 {
+		if remaining := duration - elapsed; remaining < p.RampDown {
+			if remaining < 0 {
+				remaining = 0
+			}
+			n = scaleCeil(n, int64(remaining), int64(p.RampDown))
+		}
+	}
+
+	return n
+}
+
+func (p *ProfileOptions) Enabled() bool {
+	return p.RampUp > 0 || p.RampDown > 0 || (p.Step > 0 && p.StepInterval > 0)
+}
+
+// StepName names the step of the profile the run is in after `elapsed`.
+func (p *ProfileOptions) StepName(elapsed time.Duration, duration time.Duration, full bool) string {
+	if p.RampDown > 0 && duration > 0 && duration-elapsed < p.RampDown {
+		return "ramp-down"
+	} else if full {
+		return "steady"
+	} else if p.Step > 0 && p.StepInterval > 0 {
+		return fmt.Sprintf("step-%d", int64(elapsed/p.StepInterval)+1)
+	}
+	return "ramp-up"
+}
+
+func scaleCeil(n int64, num int64, den int64) int64 {
+	return (n*num + den - 1) / den
+}
+
+// applyProfile sets the active workers and target rate of each workload
+// according to the profile, at `elapsed` into the run. It returns whether
+// every workload is running at full load.
+func applyProfile(p *ProfileOptions, workloads []*Workload, elapsed time.Duration, duration time.Duration) bool {
+
+	full := true
+
+	for _, w := range workloads {
+		if w.Workers <= 0 {
+			continue
+		}
+
+		active := p.Scale(elapsed, duration, w.Workers)
+		atomic.StoreInt64(&w.active, active)
+		if active < w.Workers {
+			full = false
+		}
+
+		if w.limiter != nil {
+			tps := w.TPS * active / w.Workers
+			if tps == 0 && active > 0 {
+				tps = 1
+			}
+			w.limiter.SetRate(tps)
+		}
+	}
+
+	return full
+}
+
+// runProfile drives the workloads through the profile until `done` is
+// closed, flushing the stats whenever the run moves on to a new step so each
+// stats line covers a single step.
+func runProfile(p *ProfileOptions, workloads []*Workload, duration time.Duration, done chan bool) {
+
+	start := time.Now()
+	step := ""
+
+	for {
+		elapsed := time.Since(start)
+		full := applyProfile(p, workloads, elapsed, duration)
+
+		if s := p.StepName(elapsed, duration, full); s != step {
+			if step != "" {
+				statsFlush()
+			}
+			step = s
+			statsStep(step)
+			logInfo("Profile entering %s", step)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(PROFILE_INTERVAL):
+		}
+	}
+}
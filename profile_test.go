@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProfileScale(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  ProfileOptions
+		elapsed  time.Duration
+		duration time.Duration
+		scale    int64
+	}{
+		{"none", ProfileOptions{}, 0, 0, 10},
+		{"ramp up start", ProfileOptions{RampUp: 10 * time.Second}, 0, 0, 0},
+		{"ramp up early", ProfileOptions{RampUp: 10 * time.Second}, time.Millisecond, 0, 1},
+		{"ramp up half", ProfileOptions{RampUp: 10 * time.Second}, 5 * time.Second, 0, 5},
+		{"ramp up done", ProfileOptions{RampUp: 10 * time.Second}, 10 * time.Second, 0, 10},
+		{"first step", ProfileOptions{Step: 2, StepInterval: 10 * time.Second}, 0, 0, 2},
+		{"second step", ProfileOptions{Step: 2, StepInterval: 10 * time.Second}, 15 * time.Second, 0, 4},
+		{"steps capped", ProfileOptions{Step: 2, StepInterval: 10 * time.Second}, time.Hour, 0, 10},
+		{"step without interval", ProfileOptions{Step: 2}, 0, 0, 10},
+		{"steps over ramp up", ProfileOptions{Step: 3, StepInterval: 10 * time.Second, RampUp: time.Hour}, 0, 0, 3},
+		{"before ramp down", ProfileOptions{RampDown: 10 * time.Second}, 40 * time.Second, time.Minute, 10},
+		{"ramp down half", ProfileOptions{RampDown: 10 * time.Second}, 55 * time.Second, time.Minute, 5},
+		{"ramp down end", ProfileOptions{RampDown: 10 * time.Second}, time.Minute, time.Minute, 0},
+		{"past ramp down", ProfileOptions{RampDown: 10 * time.Second}, 2 * time.Minute, time.Minute, 0},
+		{"ramp down unbounded", ProfileOptions{RampDown: 10 * time.Second}, time.Hour, 0, 10},
+		{"overlapping ramps", ProfileOptions{RampUp: 10 * time.Second, RampDown: 10 * time.Second}, 5 * time.Second, 12 * time.Second, 4},
+		{"step ramp down", ProfileOptions{Step: 4, StepInterval: time.Second, RampDown: 10 * time.Second}, 55 * time.Second, time.Minute, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if n := test.profile.Scale(test.elapsed, test.duration, 10); n != test.scale {
+				t.Errorf("scale = %d, want %d", n, test.scale)
+			}
+		})
+	}
+}
+
+func TestProfileStepName(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  ProfileOptions
+		elapsed  time.Duration
+		duration time.Duration
+		full     bool
+		step     string
+	}{
+		{"ramp up", ProfileOptions{RampUp: time.Minute}, time.Second, 0, false, "ramp-up"},
+		{"steady", ProfileOptions{RampUp: time.Minute}, time.Hour, 0, true, "steady"},
+		{"first step", ProfileOptions{Step: 2, StepInterval: 10 * time.Second}, 0, 0, false, "step-1"},
+		{"third step", ProfileOptions{Step: 2, StepInterval: 10 * time.Second}, 25 * time.Second, 0, false, "step-3"},
+		{"steps done", ProfileOptions{Step: 2, StepInterval: 10 * time.Second}, time.Hour, 0, true, "steady"},
+		{"ramp down", ProfileOptions{RampDown: 10 * time.Second}, 55 * time.Second, time.Minute, true, "ramp-down"},
+		{"ramp down over steps", ProfileOptions{Step: 2, StepInterval: time.Second, RampDown: 10 * time.Second}, 55 * time.Second, time.Minute, false, "ramp-down"},
+		{"ramp down unbounded", ProfileOptions{RampDown: 10 * time.Second}, time.Hour, 0, true, "steady"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if s := test.profile.StepName(test.elapsed, test.duration, test.full); s != test.step {
+				t.Errorf("step = %s, want %s", s, test.step)
+			}
+		})
+	}
+}
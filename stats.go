@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -10,18 +11,106 @@ import (
 )
 
 var (
-	CURRENT_STATS Stats = Stats{}
+	CURRENT_STATS Stats          = Stats{}
+	STATS_FLUSH   chan chan bool = make(chan chan bool)
+	STATS_RESET   chan chan bool = make(chan chan bool)
+	STATS_PHASE   atomic.Value
+	STATS_STEP    atomic.Value
+	STATS_HOTKEYS atomic.Value
 )
 
 type Stat struct {
 	Count    uint64
 	Timeouts uint64
 	Errors   uint64
+	Busy     uint64
+	Latency  uint64
+}
+
+type BatchStat struct {
+	Stat
+	Keys uint64
+}
+
+type QueryStat struct {
+	Stat
+	Records uint64
+}
+
+// ScanStat tracks scans, along with the latency of foreground operations
+// split by whether or not a scan was running when they completed.
+type ScanStat struct {
+	Stat
+	Records           uint64
+	Active            int64
+	ForegroundCount   uint64
+	ForegroundLatency uint64
+	IdleCount         uint64
+	IdleLatency       uint64
+}
+
+type UDFStat struct {
+	Stat
+	BadResponses uint64
+}
+
+// CASStat tracks optimistic concurrency writes, counting generation conflicts
+// and the retries the successful writes took.
+type CASStat struct {
+	Stat
+	Conflicts uint64
+	Retries   uint64
+}
+
+// HotKeyStat counts the operations on each hot key. Only the stats service
+// touches Previous.
+type HotKeyStat struct {
+	Keys     []string
+	Counts   []uint64
+	Previous []uint64
+}
+
+// TTLStat tracks expiration checks, where Count is every check that could
+// tell an outcome, and Errors those that could not.
+type TTLStat struct {
+	Stat
+	Early       uint64
+	Late        uint64
+	Overwritten uint64
+}
+
+// GrowStat tracks growing records, with the sizes they grew to, in elements
+// and approximate bytes, the largest since the last stats line, and
+// RECORD_TOO_BIG errors.
+type GrowStat struct {
+	Stat
+	TooBig   uint64
+	Sizes    uint64
+	Bytes    uint64
+	Sized    uint64
+	MaxSize  uint64
+	MaxBytes uint64
 }
 
 type Stats struct {
-	Reads  Stat
-	Writes Stat
+	Populates   Stat
+	Reads       Stat
+	ReadExists  Stat
+	ReadHeaders Stat
+	ReadBins    Stat
+	BatchReads  BatchStat
+	Writes      Stat
+	CASWrites   CASStat
+	Operates    Stat
+	Lists       Stat
+	Maps        Stat
+	Grows       GrowStat
+	UDFs        UDFStat
+	Deletes     Stat
+	Queries     QueryStat
+	Scans       ScanStat
+	TTLChecks   TTLStat
+	Dropped     uint64
 }
 
 func statUpdate(s *Stat, err error) {
@@ -31,6 +120,8 @@ func statUpdate(s *Stat, err error) {
 		t, ok := err.(types.AerospikeError)
 		if ok && t.ResultCode() == types.TIMEOUT {
 			statTimeout(s)
+		} else if ok && t.ResultCode() == types.KEY_BUSY {
+			statBusy(s)
 		} else {
 			statError(s)
 		}
@@ -49,41 +140,449 @@ func statError(s *Stat) {
 	atomic.AddUint64(&s.Errors, 1)
 }
 
-func statLog(n string, s *Stat, p *Stat) string {
+func statBusy(s *Stat) {
+	atomic.AddUint64(&s.Busy, 1)
+}
+
+func statUDF(s *UDFStat, err error) {
+	statUpdate(&s.Stat, err)
+	if t, ok := err.(types.AerospikeError); ok && t.ResultCode() == types.UDF_BAD_RESPONSE {
+		atomic.AddUint64(&s.BadResponses, 1)
+	}
+}
+
+// statCASConflict counts the error if it is a generation conflict, meaning
+// the record was changed or created since it was read.
+func statCASConflict(s *CASStat, err error) bool {
+	if t, ok := err.(types.AerospikeError); ok {
+		if t.ResultCode() == types.GENERATION_ERROR || t.ResultCode() == types.KEY_EXISTS_ERROR {
+			atomic.AddUint64(&s.Conflicts, 1)
+			return true
+		}
+	}
+	return false
+}
+
+func statCASRetries(s *CASStat, retries uint64) {
+	atomic.AddUint64(&s.Retries, retries)
+}
+
+func statLatency(s *Stat, latency time.Duration) {
+	atomic.AddUint64(&s.Latency, uint64(latency))
+}
+
+// statForeground records the latency of a foreground operation, and
+// attributes it to the scan stats depending on whether a scan is running.
+func statForeground(s *Stat, latency time.Duration) {
+	statLatency(s, latency)
+	if atomic.LoadInt64(&CURRENT_STATS.Scans.Active) > 0 {
+		atomic.AddUint64(&CURRENT_STATS.Scans.ForegroundCount, 1)
+		atomic.AddUint64(&CURRENT_STATS.Scans.ForegroundLatency, uint64(latency))
+	} else {
+		atomic.AddUint64(&CURRENT_STATS.Scans.IdleCount, 1)
+		atomic.AddUint64(&CURRENT_STATS.Scans.IdleLatency, uint64(latency))
+	}
+}
+
+// statHotKeys sets the hot keys reported by the stats service.
+func statHotKeys(h *HotKeyStat) {
+	STATS_HOTKEYS.Store(h)
+}
+
+func statHotKey(h *HotKeyStat, i int) {
+	atomic.AddUint64(&h.Counts[i], 1)
+}
+
+func statBatch(s *BatchStat, keys uint64) {
+	atomic.AddUint64(&s.Keys, keys)
+}
+
+func statQuery(s *QueryStat, records uint64) {
+	atomic.AddUint64(&s.Records, records)
+}
+
+func statScan(s *ScanStat, records uint64) {
+	atomic.AddUint64(&s.Records, records)
+}
+
+func statOps(s *Stat) uint64 {
+	return atomic.LoadUint64(&s.Count) + atomic.LoadUint64(&s.Timeouts) + atomic.LoadUint64(&s.Errors) + atomic.LoadUint64(&s.Busy)
+}
+
+func statAverage(latency uint64, ops uint64) time.Duration {
+	if ops == 0 {
+		return 0
+	}
+	return time.Duration(latency / ops)
+}
+
+func statFields(s *Stat, p *Stat) string {
 
 	sc := atomic.LoadUint64(&s.Count)
 	st := atomic.LoadUint64(&s.Timeouts)
 	se := atomic.LoadUint64(&s.Errors)
+	sb := atomic.LoadUint64(&s.Busy)
+	sl := atomic.LoadUint64(&s.Latency)
 
 	pc := p.Count
 	pt := p.Timeouts
 	pe := p.Errors
+	pb := p.Busy
+	pl := p.Latency
 
 	dc := sc - pc
 	dt := st - pt
 	de := se - pe
+	db := sb - pb
+	dl := sl - pl
 
 	p.Count = sc
 	p.Timeouts = st
 	p.Errors = se
+	p.Busy = sb
+	p.Latency = sl
+
+	return fmt.Sprintf("count=%d/%d, timeouts=%d/%d, errors=%d/%d, busy=%d/%d, latency=%v", dc, sc, dt, st, de, se, db, sb, statAverage(dl, dc+dt+de+db))
+}
+
+func statLog(n string, s *Stat, p *Stat) string {
+	return fmt.Sprintf("{%s: %s} ", n, statFields(s, p))
+}
+
+func batchStatLog(n string, s *BatchStat, p *BatchStat, interval time.Duration) string {
+
+	sk := atomic.LoadUint64(&s.Keys)
+	dk := sk - p.Keys
+	p.Keys = sk
+
+	var rate float64 = 0
+	if interval > 0 {
+		rate = float64(dk) / interval.Seconds()
+	}
 
-	return fmt.Sprintf("{%s: count=%d/%d, timeouts=%d/%d, errors=%d/%d} ", n, dc, sc, dt, st, de, se)
+	return fmt.Sprintf("{%s: %s, keys=%d/%d, keys/sec=%.0f} ", n, statFields(&s.Stat, &p.Stat), dk, sk, rate)
 }
 
+func casStatLog(n string, s *CASStat, p *CASStat) string {
+
+	dw := atomic.LoadUint64(&s.Count) - p.Count
+
+	sc := atomic.LoadUint64(&s.Conflicts)
+	sr := atomic.LoadUint64(&s.Retries)
+	dc := sc - p.Conflicts
+	dr := sr - p.Retries
+	p.Conflicts = sc
+	p.Retries = sr
+
+	var avg float64 = 0
+	if dw > 0 {
+		avg = float64(dr) / float64(dw)
+	}
+
+	return fmt.Sprintf("{%s: %s, conflicts=%d/%d, retries=%d/%d, retries/write=%.2f} ", n, statFields(&s.Stat, &p.Stat), dc, sc, dr, sr, avg)
+}
+
+func udfStatLog(n string, s *UDFStat, p *UDFStat) string {
+
+	sb := atomic.LoadUint64(&s.BadResponses)
+	db := sb - p.BadResponses
+	p.BadResponses = sb
+
+	return fmt.Sprintf("{%s: %s, bad-responses=%d/%d} ", n, statFields(&s.Stat, &p.Stat), db, sb)
+}
+
+func queryStatLog(n string, s *QueryStat, p *QueryStat) string {
+
+	do := statOps(&s.Stat) - statOps(&p.Stat)
+
+	sr := atomic.LoadUint64(&s.Records)
+	dr := sr - p.Records
+	p.Records = sr
+
+	var avg uint64 = 0
+	if do > 0 {
+		avg = dr / do
+	}
+
+	return fmt.Sprintf("{%s: %s, records=%d/%d, records/query=%d} ", n, statFields(&s.Stat, &p.Stat), dr, sr, avg)
+}
+
+func scanStatLog(n string, s *ScanStat, p *ScanStat, interval time.Duration) string {
+
+	sr := atomic.LoadUint64(&s.Records)
+	sfc := atomic.LoadUint64(&s.ForegroundCount)
+	sfl := atomic.LoadUint64(&s.ForegroundLatency)
+	sic := atomic.LoadUint64(&s.IdleCount)
+	sil := atomic.LoadUint64(&s.IdleLatency)
+
+	dr := sr - p.Records
+	dfc := sfc - p.ForegroundCount
+	dfl := sfl - p.ForegroundLatency
+	dic := sic - p.IdleCount
+	dil := sil - p.IdleLatency
+
+	p.Records = sr
+	p.ForegroundCount = sfc
+	p.ForegroundLatency = sfl
+	p.IdleCount = sic
+	p.IdleLatency = sil
+
+	var rate float64 = 0
+	if interval > 0 {
+		rate = float64(dr) / interval.Seconds()
+	}
+
+	return fmt.Sprintf("{%s: %s, records=%d/%d, records/sec=%.0f, active=%d, foreground-latency=%v/%v} ",
+		n, statFields(&s.Stat, &p.Stat), dr, sr, rate, atomic.LoadInt64(&s.Active),
+		statAverage(dfl, dfc), statAverage(dil, dic))
+}
+
+func hotKeyStatLog(n string, h *HotKeyStat, interval time.Duration) string {
+
+	b := bytes.NewBuffer(nil)
+	for i, k := range h.Keys {
+		sc := atomic.LoadUint64(&h.Counts[i])
+		dc := sc - h.Previous[i]
+		h.Previous[i] = sc
+
+		var rate float64 = 0
+		if interval > 0 {
+			rate = float64(dc) / interval.Seconds()
+		}
+
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(b, "%s=%.0f/s", k, rate)
+	}
+
+	return fmt.Sprintf("{%s: %s} ", n, b.String())
+}
+
+func growStatLog(n string, s *GrowStat, p *GrowStat) string {
+
+	st := atomic.LoadUint64(&s.TooBig)
+	ss := atomic.LoadUint64(&s.Sizes)
+	sb := atomic.LoadUint64(&s.Bytes)
+	sn := atomic.LoadUint64(&s.Sized)
+	max := atomic.SwapUint64(&s.MaxSize, 0)
+	maxBytes := atomic.SwapUint64(&s.MaxBytes, 0)
+
+	dt := st - p.TooBig
+	ds := ss - p.Sizes
+	db := sb - p.Bytes
+	dn := sn - p.Sized
+
+	p.TooBig = st
+	p.Sizes = ss
+	p.Bytes = sb
+	p.Sized = sn
+
+	var avg, avgBytes uint64 = 0, 0
+	if dn > 0 {
+		avg = ds / dn
+		avgBytes = db / dn
+	}
+
+	return fmt.Sprintf("{%s: %s, too-big=%d/%d, size=%d, max-size=%d, bytes=%d, max-bytes=%d} ", n, statFields(&s.Stat, &p.Stat), dt, st, avg, max, avgBytes, maxBytes)
+}
+
+func ttlStatLog(n string, s *TTLStat, p *TTLStat) string {
+
+	se := atomic.LoadUint64(&s.Early)
+	sl := atomic.LoadUint64(&s.Late)
+	so := atomic.LoadUint64(&s.Overwritten)
+	de := se - p.Early
+	dl := sl - p.Late
+	do := so - p.Overwritten
+	p.Early = se
+	p.Late = sl
+	p.Overwritten = so
+
+	return fmt.Sprintf("{%s: %s, early=%d/%d, late=%d/%d, overwritten=%d/%d} ", n, statFields(&s.Stat, &p.Stat), de, se, dl, sl, do, so)
+}
+
+func statsWrite(b *bytes.Buffer, p *Stats, interval time.Duration) {
+
+	// reads and writes are always logged, the other operation types only
+	// once they have run
+	if statOps(&CURRENT_STATS.Populates) > 0 {
+		b.WriteString(statLog("populates", &CURRENT_STATS.Populates, &p.Populates))
+	}
+	b.WriteString(statLog("reads", &CURRENT_STATS.Reads, &p.Reads))
+	if statOps(&CURRENT_STATS.ReadExists) > 0 {
+		b.WriteString(statLog("read-exists", &CURRENT_STATS.ReadExists, &p.ReadExists))
+	}
+	if statOps(&CURRENT_STATS.ReadHeaders) > 0 {
+		b.WriteString(statLog("read-headers", &CURRENT_STATS.ReadHeaders, &p.ReadHeaders))
+	}
+	if statOps(&CURRENT_STATS.ReadBins) > 0 {
+		b.WriteString(statLog("read-bins", &CURRENT_STATS.ReadBins, &p.ReadBins))
+	}
+	if statOps(&CURRENT_STATS.BatchReads.Stat) > 0 {
+		b.WriteString(batchStatLog("batch-reads", &CURRENT_STATS.BatchReads, &p.BatchReads, interval))
+	}
+	b.WriteString(statLog("writes", &CURRENT_STATS.Writes, &p.Writes))
+	if statOps(&CURRENT_STATS.CASWrites.Stat) > 0 {
+		b.WriteString(casStatLog("cas-writes", &CURRENT_STATS.CASWrites, &p.CASWrites))
+	}
+	if statOps(&CURRENT_STATS.Operates) > 0 {
+		b.WriteString(statLog("operates", &CURRENT_STATS.Operates, &p.Operates))
+	}
+	if statOps(&CURRENT_STATS.Lists) > 0 {
+		b.WriteString(statLog("lists", &CURRENT_STATS.Lists, &p.Lists))
+	}
+	if statOps(&CURRENT_STATS.Maps) > 0 {
+		b.WriteString(statLog("maps", &CURRENT_STATS.Maps, &p.Maps))
+	}
+	if statOps(&CURRENT_STATS.Grows.Stat) > 0 {
+		b.WriteString(growStatLog("grows", &CURRENT_STATS.Grows, &p.Grows))
+	}
+	if statOps(&CURRENT_STATS.UDFs.Stat) > 0 {
+		b.WriteString(udfStatLog("udfs", &CURRENT_STATS.UDFs, &p.UDFs))
+	}
+	if statOps(&CURRENT_STATS.Deletes) > 0 {
+		b.WriteString(statLog("deletes", &CURRENT_STATS.Deletes, &p.Deletes))
+	}
+	if statOps(&CURRENT_STATS.Queries.Stat) > 0 {
+		b.WriteString(queryStatLog("queries", &CURRENT_STATS.Queries, &p.Queries))
+	}
+	// scans are long, and logged while the first is still running
+	if statOps(&CURRENT_STATS.Scans.Stat) > 0 || atomic.LoadInt64(&CURRENT_STATS.Scans.Active) > 0 {
+		b.WriteString(scanStatLog("scans", &CURRENT_STATS.Scans, &p.Scans, interval))
+	}
+	if atomic.LoadUint64(&CURRENT_STATS.TTLChecks.Count)+atomic.LoadUint64(&CURRENT_STATS.TTLChecks.Errors) > 0 {
+		b.WriteString(ttlStatLog("ttl-checks", &CURRENT_STATS.TTLChecks, &p.TTLChecks))
+	}
+	if d := atomic.LoadUint64(&CURRENT_STATS.Dropped); d > 0 {
+		b.WriteString(fmt.Sprintf("{dropped: %d/%d} ", d-p.Dropped, d))
+		p.Dropped = d
+	}
+	if h, ok := STATS_HOTKEYS.Load().(*HotKeyStat); ok && h != nil {
+		b.WriteString(hotKeyStatLog("hot-keys", h, interval))
+	}
+
+	tags := []string{}
+	for _, t := range []*atomic.Value{&STATS_PHASE, &STATS_STEP} {
+		if tag, ok := t.Load().(string); ok && tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	if len(tags) > 0 {
+		logStats("[%s] %s", strings.Join(tags, " "), b.String())
+	} else {
+		logStats(b.String())
+	}
+	b.Reset()
+}
+
+// statsPhase labels the following stats lines with the running phase.
+func statsPhase(phase string) {
+	STATS_PHASE.Store(phase)
+	STATS_STEP.Store("")
+}
+
+// statsStep labels the following stats lines with the profile step.
+func statsStep(step string) {
+	STATS_STEP.Store(step)
+}
+
+// statsService logs the stats every interval, and whenever a flush is
+// requested through STATS_FLUSH. Resets requested through STATS_RESET zero
+// the stats, and must only happen while no operations are running.
 func statsService(interval time.Duration) {
 
 	p := Stats{}
 	b := bytes.NewBuffer(nil)
+	last := time.Now()
 
 	for {
 		select {
 		case <-time.After(interval):
+			statsWrite(b, &p, time.Since(last))
+			last = time.Now()
+		case done := <-STATS_FLUSH:
+			statsWrite(b, &p, time.Since(last))
+			last = time.Now()
+			done <- true
+		case done := <-STATS_RESET:
+			CURRENT_STATS = Stats{}
+			STATS_HOTKEYS.Store((*HotKeyStat)(nil))
+			p = Stats{}
+			last = time.Now()
+			done <- true
+		}
+	}
+}
 
-			b.WriteString(statLog("reads", &CURRENT_STATS.Reads, &p.Reads))
-			b.WriteString(statLog("writes", &CURRENT_STATS.Writes, &p.Writes))
+// statsFlush logs the stats accumulated since the last interval.
+func statsFlush() {
+	done := make(chan bool)
+	STATS_FLUSH <- done
+	<-done
+}
+
+// statsReset zeroes the stats, such as between phases.
+func statsReset() {
+	done := make(chan bool)
+	STATS_RESET <- done
+	<-done
+}
 
-			logStats(b.String())
-			b.Reset()
+// statsSummary logs the totals of every operation type over the elapsed run
+// time, and returns the total number of failed operations.
+func statsSummary(elapsed time.Duration) uint64 {
+
+	categories := []struct {
+		name string
+		stat *Stat
+	}{
+		{"populates", &CURRENT_STATS.Populates},
+		{"reads", &CURRENT_STATS.Reads},
+		{"read-exists", &CURRENT_STATS.ReadExists},
+		{"read-headers", &CURRENT_STATS.ReadHeaders},
+		{"read-bins", &CURRENT_STATS.ReadBins},
+		{"batch-reads", &CURRENT_STATS.BatchReads.Stat},
+		{"writes", &CURRENT_STATS.Writes},
+		{"cas-writes", &CURRENT_STATS.CASWrites.Stat},
+		{"operates", &CURRENT_STATS.Operates},
+		{"lists", &CURRENT_STATS.Lists},
+		{"maps", &CURRENT_STATS.Maps},
+		{"grows", &CURRENT_STATS.Grows.Stat},
+		{"udfs", &CURRENT_STATS.UDFs.Stat},
+		{"deletes", &CURRENT_STATS.Deletes},
+		{"queries", &CURRENT_STATS.Queries.Stat},
+		{"scans", &CURRENT_STATS.Scans.Stat},
+	}
+
+	var failures uint64 = 0
+
+	logInfo("Summary after %v:", elapsed)
+	for _, c := range categories {
+		ops := statOps(c.stat)
+		if ops == 0 {
+			continue
+		}
+
+		count := atomic.LoadUint64(&c.stat.Count)
+		timeouts := atomic.LoadUint64(&c.stat.Timeouts)
+		errors := atomic.LoadUint64(&c.stat.Errors)
+		busy := atomic.LoadUint64(&c.stat.Busy)
+		failures += timeouts + errors + busy
+
+		var rate float64 = 0
+		if elapsed > 0 {
+			rate = float64(ops) / elapsed.Seconds()
 		}
+
+		logInfo("  %s: count=%d, timeouts=%d, errors=%d, busy=%d, ops/sec=%.0f, latency=%v",
+			c.name, count, timeouts, errors, busy, rate, statAverage(atomic.LoadUint64(&c.stat.Latency), ops))
+	}
+
+	if d := atomic.LoadUint64(&CURRENT_STATS.Dropped); d > 0 {
+		logInfo("  dropped: %d open-loop dispatches, with every worker busy", d)
 	}
+
+	return failures
 }
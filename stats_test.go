@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    func(s *Stats)
+		blocks []string // logged, in order
+	}{
+		{"idle", func(s *Stats) {}, []string{"reads", "writes"}},
+		{"deletes", func(s *Stats) { s.Deletes.Count = 3 }, []string{"reads", "writes", "deletes"}},
+		{"failed", func(s *Stats) { s.Populates.Errors = 1; s.UDFs.Timeouts = 2 }, []string{"populates", "reads", "writes", "udfs"}},
+		{"scan running", func(s *Stats) { s.Scans.Active = 1 }, []string{"reads", "writes", "scans"}},
+	}
+
+	defer log.SetOutput(os.Stderr)
+	defer func() { CURRENT_STATS = Stats{} }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			CURRENT_STATS = Stats{}
+			test.run(&CURRENT_STATS)
+
+			var out bytes.Buffer
+			log.SetOutput(&out)
+			statsWrite(bytes.NewBuffer(nil), &Stats{}, time.Second)
+
+			blocks := []string{}
+			for _, f := range strings.Split(out.String(), "{")[1:] {
+				blocks = append(blocks, f[:strings.Index(f, ":")])
+			}
+			if strings.Join(blocks, " ") != strings.Join(test.blocks, " ") {
+				t.Errorf("blocks = %v, want %v", blocks, test.blocks)
+			}
+		})
+	}
+}
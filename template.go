@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyTemplate formats keys from their index, combining literal text with
+// segments in braces:
+//
+//	{index}          the index, or {index:%08d} formatted as by printf
+//	{hash}           hex digest of the index, or {hash:8} its first 8 digits
+//	{random:6}       6 letters, random but the same for each index
+//	{random:1-10}    a number from 1 to 10, random but the same for each index
+//
+// so that "user:{index:%08d}" gives user:00001234, and
+// "tenant-{random:1-10}/order-{index}" gives tenant-7/order-991. Use {{ and
+// }} for literal braces.
+type KeyTemplate struct {
+	segments []func(seed int64) string
+}
+
+func ParseKeyTemplate(s string) (*KeyTemplate, error) {
+	t := &KeyTemplate{}
+
+	var literal bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "}}"):
+			literal.WriteByte(s[i])
+			i++
+		case s[i] == '{':
+			j := strings.IndexByte(s[i:], '}')
+			if j < 0 {
+				return nil, fmt.Errorf("Unclosed segment in key template %q", s)
+			}
+			segment, err := parseKeySegment(s[i+1:i+j], len(t.segments))
+			if err != nil {
+				return nil, err
+			}
+			if literal.Len() > 0 {
+				t.segments = append(t.segments, keyLiteral(literal.String()))
+				literal.Reset()
+			}
+			t.segments = append(t.segments, segment)
+			i += j
+		default:
+			literal.WriteByte(s[i])
+		}
+	}
+	if literal.Len() > 0 {
+		t.segments = append(t.segments, keyLiteral(literal.String()))
+	}
+	return t, nil
+}
+
+func keyLiteral(s string) func(int64) string {
+	return func(int64) string {
+		return s
+	}
+}
+
+// parseKeySegment parses a segment between braces. Random segments at
+// different positions draw differently from the same index.
+func parseKeySegment(s string, position int) (func(int64) string, error) {
+	name, arg := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, arg = s[:i], s[i+1:]
+	}
+
+	salt := uint64(position+1) * 0x9e3779b97f4a7c15
+
+	switch name {
+	case "index":
+		if arg == "" {
+			arg = "%d"
+		}
+		if strings.Contains(fmt.Sprintf(arg, int64(0)), "%!") {
+			return nil, fmt.Errorf("Invalid index format %q in key template", arg)
+		}
+		return func(seed int64) string {
+			return fmt.Sprintf(arg, seed)
+		}, nil
+
+	case "hash":
+		n := 16
+		if arg != "" {
+			var err error
+			if n, err = strconv.Atoi(arg); err != nil || n < 1 || n > 16 {
+				return nil, fmt.Errorf("Invalid hash length %q in key template, from 1 to 16", arg)
+			}
+		}
+		return func(seed int64) string {
+			return fmt.Sprintf("%016x", mix64(uint64(seed)))[:n]
+		}, nil
+
+	case "random":
+		if i := strings.IndexByte(arg, '-'); i > 0 {
+			min, err1 := strconv.ParseInt(arg[:i], 10, 64)
+			max, err2 := strconv.ParseInt(arg[i+1:], 10, 64)
+			if err1 != nil || err2 != nil || max < min {
+				return nil, fmt.Errorf("Invalid random range %q in key template", arg)
+			}
+			return func(seed int64) string {
+				return strconv.FormatInt(min+int64(mix64(uint64(seed)^salt)%uint64(max-min+1)), 10)
+			}, nil
+		}
+
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("Invalid random length %q in key template", arg)
+		}
+		return func(seed int64) string {
+			b := make([]rune, n)
+			x := uint64(seed) ^ salt
+			for i := range b {
+				x = mix64(x + 0x9e3779b97f4a7c15)
+				b[i] = GENERATOR_CHARSET_ALPHA[x%uint64(len(GENERATOR_CHARSET_ALPHA))]
+			}
+			return string(b)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown segment {%s} in key template", s)
+}
+
+// Generate formats the key of an index.
+func (t *KeyTemplate) Generate(seed int64) string {
+	var b bytes.Buffer
+	for _, segment := range t.segments {
+		b.WriteString(segment(seed))
+	}
+	return b.String()
+}
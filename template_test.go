@@ -0,0 +1,112 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		seed     int64
+		want     string // exact key, or else
+		pattern  string // pattern the key matches
+	}{
+		{"plain", 7, "plain", ""},
+		{"user:{index}", 1234, "user:1234", ""},
+		{"user:{index:%08d}", 1234, "user:00001234", ""},
+		{"{index:%x}", 255, "ff", ""},
+		{"{{literal}}-{index}", 3, "{literal}-3", ""},
+		{"{hash}", 42, "", "^[0-9a-f]{16}$"},
+		{"h-{hash:8}", 42, "", "^h-[0-9a-f]{8}$"},
+		{"{random:6}", 42, "", "^[A-Za-z]{6}$"},
+		{"tenant-{random:1-10}/order-{index}", 991, "", "^tenant-([1-9]|10)/order-991$"},
+		{"{random:5-5}", 42, "5", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			tmpl, err := ParseKeyTemplate(test.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key := tmpl.Generate(test.seed)
+			if test.pattern == "" && key != test.want {
+				t.Errorf("key = %q, want %q", key, test.want)
+			}
+			if test.pattern != "" && !regexp.MustCompile(test.pattern).MatchString(key) {
+				t.Errorf("key = %q, want it to match %s", key, test.pattern)
+			}
+
+			// the same index always gives the same key, even parsed again
+			again, _ := ParseKeyTemplate(test.template)
+			for i := 0; i < 3; i++ {
+				if k := again.Generate(test.seed); k != key {
+					t.Fatalf("key = %q, then %q", key, k)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyTemplateSpread(t *testing.T) {
+	tests := []struct {
+		template string
+		distinct int // at least, over 1000 indexes
+	}{
+		{"{index}", 1000},
+		{"{hash:8}", 1000},
+		{"{random:8}", 1000},
+		{"{random:1-10}", 10},
+		{"{random:1-3}-{random:1-3}", 9},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			tmpl, err := ParseKeyTemplate(test.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seen := map[string]bool{}
+			for i := int64(0); i < 1000; i++ {
+				seen[tmpl.Generate(i)] = true
+			}
+			if len(seen) < test.distinct {
+				t.Errorf("%d distinct keys, want at least %d", len(seen), test.distinct)
+			}
+		})
+	}
+}
+
+func TestKeyTemplateErrors(t *testing.T) {
+	tests := []struct {
+		template string
+		err      string
+	}{
+		{"user:{index", "Unclosed segment"},
+		{"{index:%s %s}", "Invalid index format"},
+		{"{hash:0}", "Invalid hash length"},
+		{"{hash:17}", "Invalid hash length"},
+		{"{hash:x}", "Invalid hash length"},
+		{"{random:10-1}", "Invalid random range"},
+		{"{random:a-b}", "Invalid random range"},
+		{"{random:0}", "Invalid random length"},
+		{"{random}", "Invalid random length"},
+		{"{uuid}", "Unknown segment"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			_, err := ParseKeyTemplate(test.template)
+			if err == nil {
+				t.Fatalf("parsed, want an error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %q, want one containing %q", err.Error(), test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aerospike/aerospike-client-go"
+)
+
+// Formats of key trace files.
+//
+// A text trace has a key per line, blank lines and lines starting with #
+// being skipped. A csv trace has namespace, set and key columns, the data
+// model's namespace and set standing in for empty ones. A binary trace is a
+// sequence of keys, each a type byte followed by its value, string and bytes
+// keys being at most TRACE_MAX_KEY long:
+//
+//	'i'  integer, as a signed varint
+//	's'  string, as an unsigned varint length and the bytes
+//	'b'  bytes, as an unsigned varint length and the bytes
+//	'd'  digest, as its 20 bytes
+//
+// Text and csv keys are integers when the data model's keys are, and bytes
+// when they are bytes.
+const (
+	TRACE_TEXT   = "text"
+	TRACE_CSV    = "csv"
+	TRACE_BINARY = "binary"
+)
+
+var (
+	TRACE_MAX_KEY uint64 = 1 << 20
+)
+
+// TraceKeyGenerator replays the keys of a trace file in order, looping back to
+// the start of the file at its end, or else ending there.
+type TraceKeyGenerator struct {
+	Path   string
+	Format string
+	Loop   bool
+	Keys   *KeyConstraints
+	file   *os.File
+	reader *bufio.Reader
+	csv    *csv.Reader
+	read   int64
+	ended  int32
+	mutex  sync.Mutex
+}
+
+func NewTraceKeyGenerator(path string, format string, loop bool, keys *KeyConstraints) (*TraceKeyGenerator, error) {
+
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = TRACE_CSV
+		case ".bin":
+			format = TRACE_BINARY
+		default:
+			format = TRACE_TEXT
+		}
+	}
+
+	switch format {
+	case TRACE_TEXT, TRACE_CSV, TRACE_BINARY:
+	default:
+		return nil, fmt.Errorf("Unknown trace format %s", format)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &TraceKeyGenerator{
+		Path:   path,
+		Format: format,
+		Loop:   loop,
+		Keys:   keys,
+		file:   file,
+	}
+	g.rewind()
+	return g, nil
+}
+
+func (g *TraceKeyGenerator) rewind() {
+	g.reader = bufio.NewReader(g.file)
+	if g.Format == TRACE_CSV {
+		g.csv = csv.NewReader(g.reader)
+		g.csv.FieldsPerRecord = -1
+		g.csv.Comment = '#'
+	}
+	g.read = 0
+}
+
+// Ended tells whether the trace has no more keys.
+func (g *TraceKeyGenerator) Ended() bool {
+	return atomic.LoadInt32(&g.ended) != 0
+}
+
+func (g *TraceKeyGenerator) Close() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	atomic.StoreInt32(&g.ended, 1)
+	if g.file != nil {
+		g.file.Close()
+		g.file = nil
+	}
+}
+
+func (g *TraceKeyGenerator) GetKey() *aerospike.Key {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for !g.Ended() {
+		key, err := g.next()
+		if err == nil {
+			g.read++
+			return key
+		}
+
+		// loop, unless the file has no keys at all
+		if err == io.EOF && g.Loop && g.read > 0 {
+			if _, err = g.file.Seek(0, io.SeekStart); err == nil {
+				g.rewind()
+				continue
+			}
+		}
+
+		if err == io.EOF {
+			logInfo("Reached the end of trace %s", g.Path)
+		} else {
+			logError("Not able to read trace %s: %s", g.Path, err.Error())
+		}
+		atomic.StoreInt32(&g.ended, 1)
+		g.file.Close()
+		g.file = nil
+	}
+	return nil
+}
+
+func (g *TraceKeyGenerator) next() (*aerospike.Key, error) {
+	switch g.Format {
+	case TRACE_CSV:
+		return g.nextCSV()
+	case TRACE_BINARY:
+		return g.nextBinary()
+	}
+	return g.nextText()
+}
+
+func (g *TraceKeyGenerator) nextText() (*aerospike.Key, error) {
+	for {
+		line, err := g.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return aerospike.NewKey(g.Keys.Namespace, g.Keys.Set, g.value(line))
+		}
+	}
+}
+
+func (g *TraceKeyGenerator) nextCSV() (*aerospike.Key, error) {
+	for {
+		row, err := g.csv.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		ns, set, key := g.Keys.Namespace, g.Keys.Set, ""
+		switch len(row) {
+		case 0:
+			continue
+		case 1:
+			key = row[0]
+		case 2:
+			set, key = row[0], row[1]
+		default:
+			ns, set, key = row[0], row[1], row[2]
+		}
+
+		// skip the header
+		if g.read == 0 && strings.EqualFold(strings.TrimSpace(key), "key") {
+			continue
+		}
+
+		if ns = strings.TrimSpace(ns); ns == "" {
+			ns = g.Keys.Namespace
+		}
+		if set = strings.TrimSpace(set); set == "" {
+			set = g.Keys.Set
+		}
+		return aerospike.NewKey(ns, set, g.value(strings.TrimSpace(key)))
+	}
+}
+
+func (g *TraceKeyGenerator) nextBinary() (*aerospike.Key, error) {
+	t, err := g.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case 'i':
+		i, err := binary.ReadVarint(g.reader)
+		if err != nil {
+			return nil, traceTruncated(err)
+		}
+		return aerospike.NewKey(g.Keys.Namespace, g.Keys.Set, i)
+
+	case 's', 'b':
+		n, err := binary.ReadUvarint(g.reader)
+		if err != nil {
+			return nil, traceTruncated(err)
+		}
+		if n > TRACE_MAX_KEY {
+			return nil, fmt.Errorf("Key of %d bytes is over the maximum of %d", n, TRACE_MAX_KEY)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(g.reader, b); err != nil {
+			return nil, traceTruncated(err)
+		}
+		if t == 's' {
+			return aerospike.NewKey(g.Keys.Namespace, g.Keys.Set, string(b))
+		}
+		return aerospike.NewKey(g.Keys.Namespace, g.Keys.Set, b)
+
+	case 'd':
+		d := make([]byte, 20)
+		if _, err := io.ReadFull(g.reader, d); err != nil {
+			return nil, traceTruncated(err)
+		}
+		return aerospike.NewKeyWithDigest(g.Keys.Namespace, g.Keys.Set, nil, d)
+	}
+
+	return nil, fmt.Errorf("Unknown key type %q", t)
+}
+
+// traceTruncated reports a key cut short by the end of the file as an error,
+// rather than the end of the trace.
+func traceTruncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// value types a text key like the data model's keys.
+func (g *TraceKeyGenerator) value(s string) interface{} {
+	if g.Keys.Key.Integer != nil {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	} else if g.Keys.Key.Bytes != nil {
+		return []byte(s)
+	}
+	return s
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aerospike/aerospike-client-go"
+)
+
+var traceKeys = &KeyConstraints{
+	Namespace: "test",
+	Set:       "users",
+	Key:       Constraints{Integer: &IntegerConstraints{}},
+}
+
+// binaryTrace encodes keys as in a binary trace, integers as integer keys,
+// strings as string keys, and anything else as raw bytes.
+func binaryTrace(keys ...interface{}) string {
+	var b []byte
+	var buf [binary.MaxVarintLen64]byte
+	for _, k := range keys {
+		switch v := k.(type) {
+		case int64:
+			n := binary.PutVarint(buf[:], v)
+			b = append(append(b, 'i'), buf[:n]...)
+		case string:
+			b = append(append(b, 's'), uvarint(uint64(len(v)))...)
+			b = append(b, v...)
+		case []byte:
+			b = append(b, v...)
+		}
+	}
+	return string(b)
+}
+
+func uvarint(n uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return buf[:binary.PutUvarint(buf[:], n)]
+}
+
+func writeTrace(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func describeKey(k *aerospike.Key) string {
+	return fmt.Sprintf("%s/%s/%v", k.Namespace(), k.SetName(), k.Value())
+}
+
+func TestTraceKeyGenerator(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		format  string
+		loop    bool
+		content string
+		keys    []string
+	}{
+		{
+			name:    "text",
+			file:    "keys.txt",
+			content: "1\n# comment\n\n  2  \nthree",
+			keys:    []string{"test/users/1", "test/users/2", "test/users/three"},
+		},
+		{
+			name:    "text loop",
+			file:    "keys.txt",
+			loop:    true,
+			content: "1\n2\n",
+			keys:    []string{"test/users/1", "test/users/2", "test/users/1", "test/users/2", "test/users/1"},
+		},
+		{
+			name:    "empty loop",
+			file:    "keys.txt",
+			loop:    true,
+			content: "# no keys\n",
+		},
+		{
+			name:    "csv",
+			file:    "keys.csv",
+			content: "namespace,set,key\nbar,orders,1\n,,2\nevents,3\n4\n",
+			keys:    []string{"bar/orders/1", "test/users/2", "test/events/3", "test/users/4"},
+		},
+		{
+			name:    "csv single column header",
+			file:    "keys.csv",
+			content: "key\n5\n",
+			keys:    []string{"test/users/5"},
+		},
+		{
+			name:    "csv format",
+			file:    "keys.txt",
+			format:  TRACE_CSV,
+			content: "orders,6\n",
+			keys:    []string{"test/orders/6"},
+		},
+		{
+			name:    "binary",
+			file:    "keys.bin",
+			content: binaryTrace(int64(42), "user:7", int64(-1)),
+			keys:    []string{"test/users/42", "test/users/user:7", "test/users/-1"},
+		},
+		{
+			name:    "binary loop",
+			file:    "keys.bin",
+			loop:    true,
+			content: binaryTrace(int64(1), int64(2)),
+			keys:    []string{"test/users/1", "test/users/2", "test/users/1"},
+		},
+		{
+			name:    "binary truncated",
+			file:    "keys.bin",
+			loop:    true,
+			content: binaryTrace(int64(1), []byte{'s', 5, 'a', 'b'}),
+			keys:    []string{"test/users/1"},
+		},
+		{
+			name:    "binary oversize",
+			file:    "keys.bin",
+			content: binaryTrace(int64(1), []byte{'b'}, uvarint(TRACE_MAX_KEY+1)),
+			keys:    []string{"test/users/1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewTraceKeyGenerator(writeTrace(t, test.file, test.content), test.format, test.loop, traceKeys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer g.Close()
+
+			var keys []string
+			for len(keys) < len(test.keys) {
+				k := g.GetKey()
+				if k == nil {
+					break
+				}
+				keys = append(keys, describeKey(k))
+			}
+			if strings.Join(keys, " ") != strings.Join(test.keys, " ") {
+				t.Fatalf("keys = %v, want %v", keys, test.keys)
+			}
+
+			// only a trace that loops goes on
+			if !test.loop || len(test.keys) == 0 {
+				if k := g.GetKey(); k != nil {
+					t.Fatalf("key %s past the end", describeKey(k))
+				}
+				if !g.Ended() {
+					t.Fatalf("not ended")
+				}
+			}
+		})
+	}
+}
+
+func TestTraceBinaryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"end", "", io.EOF.Error()},
+		{"truncated integer", "i\x80", io.ErrUnexpectedEOF.Error()},
+		{"truncated length", "s", io.ErrUnexpectedEOF.Error()},
+		{"truncated string", "s\x05ab", io.ErrUnexpectedEOF.Error()},
+		{"truncated digest", "d0123456789", io.ErrUnexpectedEOF.Error()},
+		{"oversize", "b" + string(uvarint(TRACE_MAX_KEY+1)), "over the maximum"},
+		{"unknown type", "x", "Unknown key type"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := NewTraceKeyGenerator(writeTrace(t, "keys.bin", test.content), "", false, traceKeys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer g.Close()
+
+			_, err = g.next()
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error %v, want one containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestTraceDigest(t *testing.T) {
+	digest := []byte("0123456789abcdefghij")
+
+	g, err := NewTraceKeyGenerator(writeTrace(t, "keys.bin", "d"+string(digest)), "", false, traceKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	k := g.GetKey()
+	if k == nil {
+		t.Fatal("no key")
+	}
+	if string(k.Digest()) != string(digest) {
+		t.Errorf("digest = %x, want %x", k.Digest(), digest)
+	}
+}
+
+func TestTraceFormat(t *testing.T) {
+	tests := []struct {
+		file   string
+		format string
+		want   string
+	}{
+		{"keys.txt", "", TRACE_TEXT},
+		{"keys", "", TRACE_TEXT},
+		{"keys.CSV", "", TRACE_CSV},
+		{"keys.bin", "", TRACE_BINARY},
+		{"keys.bin", TRACE_TEXT, TRACE_TEXT},
+		{"keys.txt", "parquet", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file+"/"+test.format, func(t *testing.T) {
+			g, err := NewTraceKeyGenerator(writeTrace(t, test.file, ""), test.format, false, traceKeys)
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("opened as %s, want an error", g.Format)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer g.Close()
+
+			if g.Format != test.want {
+				t.Errorf("format = %s, want %s", g.Format, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aerospike/aerospike-client-go"
+	"github.com/aerospike/aerospike-client-go/types"
+)
+
+var (
+	TTL_VERIFY_MAX      int           = 100000
+	TTL_VERIFY_INTERVAL time.Duration = 100 * time.Millisecond
+)
+
+// TTLGenerator returns a function picking ttls from the weighted buckets of
+// the distribution if any, or else uniformly from its range. It returns nil
+// for an empty distribution, leaving the ttl to the write policy.
+func TTLGenerator(d *TTLDistribution) func() int64 {
+
+	if len(d.Buckets) > 0 {
+		weights := make([]int64, len(d.Buckets))
+		for i, b := range d.Buckets {
+			weights[i] = b.Weight
+		}
+		mix := NewMix(weights...)
+
+		return func() int64 {
+			if i := mix.Pick(); i >= 0 {
+				return d.Buckets[i].TTL
+			}
+			return d.Buckets[0].TTL
+		}
+	}
+
+	if d.Max > 0 {
+		return func() int64 {
+			return randomInRange(d.Min, d.Max)
+		}
+	}
+
+	return nil
+}
+
+// ttlCheck is a scheduled read of a sampled record. Early checks happen
+// halfway through its life, and expect it to be found. Late checks happen
+// once it expired, and expect it to be gone.
+type ttlCheck struct {
+	Key        *aerospike.Key
+	Generation uint32
+	Due        time.Time
+	Late       bool
+}
+
+type ttlChecks []*ttlCheck
+
+func (c ttlChecks) Len() int            { return len(c) }
+func (c ttlChecks) Less(i, j int) bool  { return c[i].Due.Before(c[j].Due) }
+func (c ttlChecks) Swap(i, j int)       { c[i], c[j] = c[j], c[i] }
+func (c *ttlChecks) Push(x interface{}) { *c = append(*c, x.(*ttlCheck)) }
+func (c *ttlChecks) Pop() interface{} {
+	old := *c
+	n := len(old)
+	x := old[n-1]
+	*c = old[0 : n-1]
+	return x
+}
+
+// TTLVerifier remembers when a sample of written records should expire, then
+// reads them back to count those gone early and those still visible after
+// their ttl. Records rewritten in the meantime, told apart by their
+// generation, are not counted.
+type TTLVerifier struct {
+	Client *aerospike.Client
+	Sample float64
+	Grace  time.Duration
+	policy *aerospike.BasePolicy
+	checks ttlChecks
+	mutex  sync.Mutex
+}
+
+func NewTTLVerifier(client *aerospike.Client, options *TTLVerifyOptions) *TTLVerifier {
+	return &TTLVerifier{
+		Client: client,
+		Sample: options.Sample,
+		Grace:  options.Grace,
+		policy: aerospike.NewPolicy(),
+		checks: ttlChecks{},
+	}
+}
+
+// Sampled decides whether a write with the given ttl is verified. Only
+// writes with a ttl of their own can be.
+func (v *TTLVerifier) Sampled(ttl int64) bool {
+	return ttl > 0 && rand.Float64() < v.Sample
+}
+
+// Track schedules the checks of a record just written with the given ttl.
+func (v *TTLVerifier) Track(key *aerospike.Key, generation uint32, ttl int64) {
+
+	written := time.Now()
+	life := time.Duration(ttl) * time.Second
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if len(v.checks)+2 > TTL_VERIFY_MAX {
+		return
+	}
+	heap.Push(&v.checks, &ttlCheck{key, generation, written.Add(life / 2), false})
+	heap.Push(&v.checks, &ttlCheck{key, generation, written.Add(life + v.Grace), true})
+}
+
+func (v *TTLVerifier) due() *ttlCheck {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if len(v.checks) > 0 && !v.checks[0].Due.After(time.Now()) {
+		return heap.Pop(&v.checks).(*ttlCheck)
+	}
+	return nil
+}
+
+func (v *TTLVerifier) check(c *ttlCheck) {
+
+	rec, err := v.Client.GetHeader(v.policy, c.Key)
+
+	found := err == nil && rec != nil
+	if err != nil {
+		if t, ok := err.(types.AerospikeError); !ok || t.ResultCode() != types.KEY_NOT_FOUND_ERROR {
+			statError(&CURRENT_STATS.TTLChecks.Stat)
+			return
+		}
+	}
+
+	if found && uint32(rec.Generation) != c.Generation {
+		statTTLCheck(&CURRENT_STATS.TTLChecks, &CURRENT_STATS.TTLChecks.Overwritten)
+	} else if c.Late && found {
+		statTTLCheck(&CURRENT_STATS.TTLChecks, &CURRENT_STATS.TTLChecks.Late)
+	} else if !c.Late && !found {
+		statTTLCheck(&CURRENT_STATS.TTLChecks, &CURRENT_STATS.TTLChecks.Early)
+	} else {
+		statSuccess(&CURRENT_STATS.TTLChecks.Stat)
+	}
+}
+
+// Run performs the checks as they come due, until `done` is closed.
+func (v *TTLVerifier) Run(done chan bool) {
+	for {
+		for c := v.due(); c != nil; c = v.due() {
+			v.check(c)
+		}
+
+		select {
+		case <-done:
+			v.mutex.Lock()
+			pending := len(v.checks)
+			v.mutex.Unlock()
+			if pending > 0 {
+				logInfo("TTL verification stopped with %d checks pending", pending)
+			}
+			return
+		case <-time.After(TTL_VERIFY_INTERVAL):
+		}
+	}
+}
+
+// ttlPolicy returns the write policy with a ttl drawn from `ttls`, or the
+// policy itself without a ttl distribution.
+func ttlPolicy(policy *aerospike.WritePolicy, ttls func() int64) *aerospike.WritePolicy {
+	if ttls == nil {
+		return policy
+	}
+	p := *policy
+	p.Expiration = int32(ttls())
+	return &p
+}
+
+// ttlWrite writes a record with a ttl from the distribution, and when the
+// write is sampled, reads back its header in the same operation so its
+// expiration can be verified.
+func ttlWrite(client *aerospike.Client, policy *aerospike.WritePolicy, key *aerospike.Key, bins []*aerospike.Bin, ttls func() int64, verifier *TTLVerifier) error {
+
+	policy = ttlPolicy(policy, ttls)
+	ttl := int64(policy.Expiration)
+
+	if verifier == nil || !verifier.Sampled(ttl) {
+		return client.PutBins(policy, key, bins...)
+	}
+
+	ops := make([]*aerospike.Operation, 0, len(bins)+1)
+	for _, b := range bins {
+		ops = append(ops, aerospike.PutOp(b))
+	}
+	ops = append(ops, aerospike.GetHeaderOp())
+
+	rec, err := client.Operate(policy, key, ops...)
+	if err == nil && rec != nil {
+		verifier.Track(key, uint32(rec.Generation), ttl)
+	}
+	return err
+}
+
+func statTTLCheck(s *TTLStat, outcome *uint64) {
+	atomic.AddUint64(&s.Count, 1)
+	atomic.AddUint64(outcome, 1)
+}
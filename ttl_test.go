@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestTTLDistributionCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		distribution TTLDistribution
+		err          string
+	}{
+		{"empty", TTLDistribution{}, ""},
+		{"range", TTLDistribution{Min: 60, Max: 3600}, ""},
+		{"max only", TTLDistribution{Max: 3600}, ""},
+		{"min only", TTLDistribution{Min: 60}, "no max"},
+		{"reversed", TTLDistribution{Min: 3600, Max: 60}, "not above its min"},
+		{"empty range", TTLDistribution{Min: 60, Max: 60}, "not above its min"},
+		{"buckets", TTLDistribution{Buckets: []TTLBucket{{TTL: 60, Weight: 0}, {TTL: 3600, Weight: 1}}}, ""},
+		{"zero weights", TTLDistribution{Buckets: []TTLBucket{{TTL: 60}, {TTL: 3600}}}, "zero weight"},
+		{"buckets over range", TTLDistribution{Min: 60, Buckets: []TTLBucket{{TTL: 60, Weight: 1}}}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.distribution.Check()
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("error %q, want none", err.Error())
+			case test.err != "" && err == nil:
+				t.Errorf("no error, want one containing %q", test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("error %q, want one containing %q", err.Error(), test.err)
+			}
+		})
+	}
+}
+
+func TestTTLGenerator(t *testing.T) {
+	tests := []struct {
+		name         string
+		distribution TTLDistribution
+		shares       map[int64]float64 // expected share of each ttl, nil for a range
+		min, max     int64             // range of the ttls, max exclusive
+	}{
+		{"empty", TTLDistribution{}, nil, 0, 0},
+		{"range", TTLDistribution{Min: 60, Max: 120}, nil, 60, 120},
+		{"max only", TTLDistribution{Max: 10}, nil, 0, 10},
+		{"single bucket", TTLDistribution{Buckets: []TTLBucket{{TTL: 60, Weight: 1}}}, map[int64]float64{60: 1}, 0, 0},
+		{"weighted buckets", TTLDistribution{Buckets: []TTLBucket{{TTL: 60, Weight: 1}, {TTL: 3600, Weight: 3}}}, map[int64]float64{60: 0.25, 3600: 0.75}, 0, 0},
+		{"zero weight bucket", TTLDistribution{Buckets: []TTLBucket{{TTL: 60}, {TTL: 3600, Weight: 1}}}, map[int64]float64{3600: 1}, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ttls := TTLGenerator(&test.distribution)
+			if test.shares == nil && test.max == 0 {
+				if ttls != nil {
+					t.Fatalf("ttls drawn from an empty distribution")
+				}
+				return
+			}
+
+			const draws = 20000
+			counts := map[int64]int{}
+			for n := 0; n < draws; n++ {
+				ttl := ttls()
+				if test.shares == nil && (ttl < test.min || ttl >= test.max) {
+					t.Fatalf("ttl %d outside [%d, %d)", ttl, test.min, test.max)
+				}
+				counts[ttl]++
+			}
+
+			for ttl, share := range test.shares {
+				if got := float64(counts[ttl]) / draws; math.Abs(got-share) > 0.02 {
+					t.Errorf("ttl %d drawn %.3f of the time, want %.3f", ttl, got, share)
+				}
+			}
+			for ttl := range counts {
+				if _, ok := test.shares[ttl]; test.shares != nil && !ok {
+					t.Errorf("ttl %d drawn, not in any weighted bucket", ttl)
+				}
+			}
+		})
+	}
+}
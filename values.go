@@ -154,6 +154,18 @@ func GenerateValueSeed(c *Constraints, seed int64) interface{} {
 	return nil
 }
 
+// GenerateKeySeed generates the key of an index, from the key template when
+// there is one.
+func GenerateKeySeed(c *KeyConstraints, seed int64) interface{} {
+	if c.template != nil {
+		if c.Key.Bytes != nil {
+			return []byte(c.template.Generate(seed))
+		}
+		return c.template.Generate(seed)
+	}
+	return GenerateValueSeed(&c.Key, seed)
+}
+
 func GenerateBin(c *BinConstraints) *as.Bin {
 	b := as.NewBin(c.Name, GenerateValue(&c.Value))
 	return b